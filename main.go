@@ -1,11 +1,17 @@
 package main
 
+import "bytes"
+import "flag"
+import "fmt"
 import "github.com/srm88/twistr/twistr"
+import "io"
 import "log"
 import "os"
 import "os/signal"
 import "path/filepath"
-import "regexp"
+import "runtime"
+import "strings"
+import "time"
 
 func isServer(ui twistr.UI) bool {
 	var reply string
@@ -18,24 +24,552 @@ func choosePlayer(ui twistr.UI) (player twistr.Aff) {
 	return
 }
 
-var ValidName = regexp.MustCompile(`^[a-z0-9-$_.]+$`)
-
 func chooseName(ui twistr.UI) string {
 	var reply string
 	twistr.Input(ui, &reply, "Choose a name for this game")
-	for !ValidName.MatchString(reply) {
+	for !twistr.ValidName.MatchString(reply) {
 		twistr.Input(ui, &reply, "Choose a name for this game (a-z0-9-_.$ chars allowed)")
 	}
 	return reply
 }
 
+// chooseGame lets the host start a new game or resume a saved one, and
+// manage the saved games meanwhile.
+func chooseGame(ui twistr.UI) (string, twistr.Aff) {
+	for {
+		games, err := twistr.ListGames()
+		if err != nil {
+			ui.Message(err.Error())
+		}
+		b := new(bytes.Buffer)
+		twistr.WriteGames(b, games)
+		ui.ShowMessages(strings.Split(strings.TrimRight(b.String(), "\n"), "\n"))
+		names := []string{}
+		for _, sg := range games {
+			names = append(names, sg.Name)
+		}
+		var reply string
+		twistr.Input(ui, &reply, "Resume a game, or new, archive, rename, delete, export",
+			append(names, "new", "archive", "rename", "delete", "export")...)
+		switch reply {
+		case "new":
+			return chooseName(ui), choosePlayer(ui)
+		case "archive", "rename", "delete", "export":
+			var name string
+			twistr.Input(ui, &name, fmt.Sprintf("Which game to %s?", reply), names...)
+			if err := manageGame(ui, reply, name); err != nil {
+				ui.Message(err.Error())
+			}
+		default:
+			sg, err := twistr.FindGame(reply)
+			if err != nil {
+				ui.Message(err.Error())
+				continue
+			}
+			return sg.Name, resumeSide(ui, sg)
+		}
+	}
+}
+
+// resumeSide is the side we host a saved game as. Games saved before AOFs
+// had headers do not record it.
+func resumeSide(ui twistr.UI, sg *twistr.SavedGame) twistr.Aff {
+	switch {
+	case sg.Header == nil:
+		return choosePlayer(ui)
+	case sg.Guest:
+		return sg.Header.Host.Opp()
+	default:
+		return sg.Header.Host
+	}
+}
+
+func manageGame(ui twistr.UI, action, name string) error {
+	switch action {
+	case "archive":
+		return twistr.ArchiveGame(name)
+	case "rename":
+		var newName string
+		twistr.Input(ui, &newName, "New name")
+		return twistr.RenameGame(name, newName)
+	case "delete":
+		var reply string
+		twistr.Input(ui, &reply, fmt.Sprintf("Delete %s for good?", name), "yes", "no")
+		if reply != "yes" {
+			return nil
+		}
+		return twistr.DeleteGame(name)
+	case "export":
+		var path string
+		twistr.Input(ui, &path, "Export to which file?")
+		return exportGame(name, path)
+	}
+	return nil
+}
+
+func exportGame(name, path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return twistr.ExportGame(name, out)
+}
+
+// gamesCommand runs 'twistr games', which manages the saved games from the
+// command line. Resuming a game is left to the caller.
+func gamesCommand(args []string) (resume string, code int) {
+	usage := "usage: twistr games [list | resume NAME | archive NAME | rename NAME NEWNAME | delete NAME | export NAME FILE]"
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	want := map[string]int{"list": 1, "resume": 2, "archive": 2, "rename": 3, "delete": 2, "export": 3}
+	if n, ok := want[args[0]]; !ok || n != len(args) {
+		fmt.Fprintln(os.Stderr, usage)
+		return "", 2
+	}
+	var err error
+	switch args[0] {
+	case "list":
+		var games []*twistr.SavedGame
+		if games, err = twistr.ListGames(); err == nil {
+			err = twistr.WriteGames(os.Stdout, games)
+		}
+	case "resume":
+		if _, err = twistr.FindGame(args[1]); err == nil {
+			return args[1], 0
+		}
+	case "archive":
+		err = twistr.ArchiveGame(args[1])
+	case "rename":
+		err = twistr.RenameGame(args[1], args[2])
+	case "delete":
+		err = twistr.DeleteGame(args[1])
+	case "export":
+		if args[2] == "-" {
+			err = twistr.ExportGame(args[1], os.Stdout)
+		} else {
+			err = exportGame(args[1], args[2])
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return "", 1
+	}
+	return "", 0
+}
+
 type Match interface {
 	Run() error
 	Close()
 }
 
-// Temp:
+const usage = `usage: twistr [command] [flags]
+
+Commands:
+  host        host a game, new or saved
+  join        join a game as the guest
+  replay      replay a saved game
+  games       list and manage saved games
+  validate    verify saved games or AOF files
+  export      write a saved game's record as Markdown or HTML
+  notate      convert a saved game's inputs to move notation, or back
+  import      replay another client's game log, and save it as a game
+  stats       report statistics across the finished saved games
+  bot         play a game against a bot
+  tournament  play bots against each other and report how they fare
+
+With no command, twistr asks whether to host or join.
+Run 'twistr <command> -h' for the command's flags.
+`
+
+type options struct {
+	name string
+	side string
+	addr string
+	port int
+	ui   string
+	data string
+	kind string
+	// How long a searching bot thinks over each decision.
+	playouts int
+	think    time.Duration
+	// Tournament games, the first's seed, and how many to play at once.
+	games    int
+	seed     int64
+	parallel int
+	log      string
+	// Comma separated areas to trace; see twistr.Trace.
+	trace  string
+	format string
+	out    string
+	read   bool
+	// Player names for an imported game.
+	players [2]string
+}
+
+func parseFlags(cmd string, args []string) (*options, []string) {
+	// Commands without a flag still get its default.
+	o := &options{
+		addr:  "localhost",
+		port:  1550,
+		ui:    "ncurses",
+		data:  twistr.DefaultDataDir(),
+		log:   "info",
+		trace: os.Getenv("TWISTR_TRACE"),
+	}
+	if level := os.Getenv("TWISTR_LOG"); level != "" {
+		o.log = level
+	}
+	fs := flag.NewFlagSet("twistr "+cmd, flag.ExitOnError)
+	fs.StringVar(&o.data, "data", o.data, "directory for saved games and logs (or $TWISTR_DATA)")
+	fs.StringVar(&o.log, "log", o.log, "log level: debug, info, warn or error (or $TWISTR_LOG)")
+	fs.StringVar(&o.trace, "trace", o.trace, "debug log these areas regardless of level, e.g. replay,commit (or $TWISTR_TRACE)")
+	switch cmd {
+	case "", "host", "join", "replay", "bot":
+		fs.StringVar(&o.ui, "ui", o.ui, "user interface: ncurses or term")
+	}
+	switch cmd {
+	case "host", "bot":
+		fs.StringVar(&o.name, "name", "", "name of the game")
+		fs.StringVar(&o.side, "side", "", "side to play: usa or ussr")
+	}
+	switch cmd {
+	case "", "host", "join":
+		fs.IntVar(&o.port, "port", o.port, "port the host listens on")
+	}
+	switch cmd {
+	case "", "join":
+		fs.StringVar(&o.addr, "addr", o.addr, "address of the host")
+	}
+	if cmd == "bot" {
+		fs.StringVar(&o.kind, "kind", "random", "kind of bot to play against: "+strings.Join(twistr.BotKinds(), ", "))
+	}
+	if cmd == "tournament" {
+		fs.IntVar(&o.games, "games", 100, "number of games to play")
+		fs.Int64Var(&o.seed, "seed", 1, "seed of the first game; each game's seed is one more")
+		fs.IntVar(&o.parallel, "parallel", runtime.NumCPU(), "number of games to play at once")
+	}
+	switch cmd {
+	case "bot", "tournament":
+		fs.IntVar(&o.playouts, "playouts", 0, "playouts an mcts bot makes per decision, instead of thinking for -think")
+		fs.DurationVar(&o.think, "think", 2*time.Second, "time an mcts bot thinks over each decision")
+	}
+	switch cmd {
+	case "export", "notate":
+		fs.StringVar(&o.out, "o", "-", "file to write to, or - for stdout")
+	}
+	if cmd == "export" {
+		fs.StringVar(&o.format, "format", "markdown", "record format: markdown or html")
+	}
+	if cmd == "import" {
+		fs.StringVar(&o.name, "name", "", "save the imported game under this name")
+		fs.StringVar(&o.players[twistr.USA], "us", "US", "name of the US player")
+		fs.StringVar(&o.players[twistr.SOV], "ussr", "USSR", "name of the USSR player")
+	}
+	if cmd == "notate" {
+		fs.BoolVar(&o.read, "read", false, "read notation and write the bare inputs")
+	}
+	fs.Parse(args)
+	return o, fs.Args()
+}
+
+func (o *options) budget() twistr.Budget {
+	return twistr.Budget{Playouts: o.playouts, Time: o.think}
+}
+
+func makeUI(kind string) (twistr.UI, error) {
+	switch kind {
+	case "ncurses":
+		return twistr.MakeNCursesUI(), nil
+	case "term":
+		return twistr.MakeTerminalUI(), nil
+	default:
+		return nil, fmt.Errorf("Unknown UI '%s'", kind)
+	}
+}
+
+// validateCommand runs 'twistr validate', which verifies each AOF named, by
+// saved game name or by path.
+func validateCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: twistr validate NAME|FILE ...")
+		return 2
+	}
+	code := 0
+	for _, arg := range args {
+		header, inputs, err := twistr.ValidateAof(aofPath(arg))
+		switch {
+		case err != nil:
+			fmt.Printf("%s: %s\n", arg, err.Error())
+			code = 1
+		case header == nil:
+			fmt.Printf("%s: ok, %d inputs, format 0 (no header, unverified)\n", arg, len(inputs))
+		default:
+			fmt.Printf("%s: ok, %d inputs, format %d\n", arg, len(inputs), header.Format)
+		}
+	}
+	return code
+}
+
+// aofPath is the AOF of the saved game called arg, or else arg itself.
+func aofPath(arg string) string {
+	if sg, err := twistr.FindGame(arg); err == nil {
+		return sg.AofPath()
+	}
+	return arg
+}
+
+// exportCommand runs 'twistr export', which replays a game and writes its
+// record.
+func exportCommand(opts *options, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: twistr export [-format markdown|html] [-o FILE] NAME|FILE")
+	}
+	header, inputs, err := twistr.ValidateAof(aofPath(args[0]))
+	if err != nil {
+		return err
+	}
+	rec, err := twistr.ReplayRecord(inputs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "twistr: %s; the record stops there\n", err.Error())
+	}
+	rec.Name = strings.TrimSuffix(filepath.Base(args[0]), ".aof")
+	rec.Header = header
+	out, err := createOut(opts.out)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	switch opts.format {
+	case "markdown", "md":
+		return rec.WriteMarkdown(out)
+	case "html":
+		return rec.WriteHTML(out)
+	default:
+		return fmt.Errorf("Unknown format '%s'", opts.format)
+	}
+}
+
+// notateCommand runs 'twistr notate', which writes a game's inputs in move
+// notation or, with -read, checks moves and writes them back as bare inputs.
+func notateCommand(opts *options, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: twistr notate [-read] [-o FILE] NAME|FILE")
+	}
+	var lines []string
+	if opts.read {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		moves, err := twistr.ReadMoves(f)
+		if err != nil {
+			return err
+		}
+		if lines, err = twistr.MoveInputs(moves); err != nil {
+			return err
+		}
+	} else {
+		_, inputs, err := twistr.ValidateAof(aofPath(args[0]))
+		if err != nil {
+			return err
+		}
+		moves, err := twistr.Notate(inputs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "twistr: %s; the moves stop there\n", err.Error())
+		}
+		for _, m := range moves {
+			lines = append(lines, m.String())
+		}
+	}
+	out, err := createOut(opts.out)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(out, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importCommand runs 'twistr import', which replays another client's game
+// log and reports where, if anywhere, twistr disagrees with it.
+func importCommand(opts *options, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: twistr import [-name NAME] [-us NAME] [-ussr NAME] FILE")
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	facts, ignored, err := twistr.ParseGameLog(f)
+	if err != nil {
+		return err
+	}
+	if len(ignored) > 0 {
+		fmt.Printf("%d lines not understood, the first is line %d\n", len(ignored), ignored[0])
+	}
+	inputs, err := twistr.ImportGame(facts)
+	if err != nil {
+		// A game cut short where the log disagrees isn't saved.
+		return fmt.Errorf("Disagrees with the log: %s", err.Error())
+	}
+	fmt.Printf("Agrees with the log, %d inputs\n", len(inputs))
+	if opts.name != "" {
+		header := twistr.NewAofHeader(twistr.USA, opts.players, twistr.RNGImported)
+		if err := twistr.SaveImportedGame(opts.name, header, inputs); err != nil {
+			return err
+		}
+		fmt.Printf("Saved %d inputs as %s\n", len(inputs), opts.name)
+	}
+	return nil
+}
+
+// statsCommand runs 'twistr stats', which brings the statistics up to date
+// with the saved games and reports them.
+func statsCommand(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: twistr stats")
+	}
+	st, err := twistr.LoadStats()
+	if err != nil {
+		return err
+	}
+	read, err := st.Update()
+	if err != nil {
+		return err
+	}
+	if read > 0 {
+		if err := st.Save(); err != nil {
+			return err
+		}
+	}
+	return st.WriteReport(os.Stdout)
+}
+
+// tournamentCommand runs 'twistr tournament KIND KIND', which plays two kinds
+// of bot against each other and reports how they fared.
+func tournamentCommand(opts *options, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: twistr tournament [flags] KIND KIND; kinds are %s",
+			strings.Join(twistr.BotKinds(), ", "))
+	}
+	t := &twistr.Tournament{
+		Kinds:    [2]string{args[0], args[1]},
+		Games:    opts.games,
+		Seed:     opts.seed,
+		Parallel: opts.parallel,
+		Budget:   opts.budget(),
+	}
+	games, err := t.Run(func(tg twistr.TournamentGame) {
+		fmt.Fprintln(os.Stderr, tg)
+	})
+	if err != nil {
+		return err
+	}
+	return twistr.WriteTournamentReport(os.Stdout, t.Kinds, games)
+}
+
+// createOut opens the file to write a command's output to; "-" is stdout.
+func createOut(path string) (*os.File, error) {
+	if path == "-" {
+		return os.Stdout, nil
+	}
+	return os.Create(path)
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "twistr: %s\n", err.Error())
+	os.Exit(1)
+}
+
 func main() {
+	cmd, args := "", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	switch cmd {
+	case "", "host", "join", "replay", "games", "validate", "export", "notate", "import", "stats", "bot", "tournament":
+	case "help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	opts, args := parseFlags(cmd, args)
+	if err := twistr.SetDataDir(opts.data); err != nil {
+		fail(err)
+	}
+	level, err := twistr.ParseLevel(opts.log)
+	if err != nil {
+		fail(err)
+	}
+	twistr.SetLogLevel(level)
+	twistr.Trace(opts.trace)
+
+	var resume string
+	switch cmd {
+	case "games":
+		var code int
+		if resume, code = gamesCommand(args); resume == "" {
+			os.Exit(code)
+		}
+		cmd = "host"
+	case "validate":
+		os.Exit(validateCommand(args))
+	case "export":
+		if err := exportCommand(opts, args); err != nil {
+			fail(err)
+		}
+		return
+	case "notate":
+		if err := notateCommand(opts, args); err != nil {
+			fail(err)
+		}
+		return
+	case "import":
+		if err := importCommand(opts, args); err != nil {
+			fail(err)
+		}
+		return
+	case "stats":
+		if err := statsCommand(args); err != nil {
+			fail(err)
+		}
+		return
+	case "tournament":
+		if err := tournamentCommand(opts, args); err != nil {
+			fail(err)
+		}
+		return
+	}
+
+	var side twistr.Aff
+	if opts.side != "" {
+		if err := twistr.Unmarshal(opts.side, &side); err != nil {
+			fail(err)
+		}
+	}
+	if opts.name != "" {
+		if !twistr.ValidName.MatchString(opts.name) {
+			fail(fmt.Errorf("Invalid game name '%s'", opts.name))
+		}
+		resume = opts.name
+	}
+	var bot twistr.Agent
+	if cmd == "bot" {
+		if bot, err = twistr.NewBot(opts.kind, time.Now().UnixNano(), opts.budget()); err != nil {
+			fail(err)
+		}
+	}
+
 	logFile, err := os.OpenFile(filepath.Join(twistr.DataDir, "twistr.log"), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
 		log.Fatalf("error opening file: %v", err)
@@ -43,36 +577,87 @@ func main() {
 	defer logFile.Close()
 	log.SetOutput(logFile)
 
-	ui := twistr.MakeNCursesUI()
+	ui, err := makeUI(opts.ui)
+	if err != nil {
+		fail(err)
+	}
 
 	var match Match
-
-	if isServer(ui) {
-		// Need to tell the opponent who they are!
-		// Where should this happen? Should address when we formalize loading previous games
-		match = twistr.NewHostMatch(ui, chooseName(ui), choosePlayer(ui))
-	} else {
-		match = twistr.NewGuestMatch(ui)
+	// Prompts panic with io.EOF if no one is left to answer; see
+	// twistr.Solicit.
+	err = func() (err error) {
+		defer func() {
+			if x := recover(); x == io.EOF {
+				err = io.EOF
+			} else if x != nil {
+				panic(x)
+			}
+		}()
+		switch {
+		case cmd == "replay":
+			if len(args) != 1 {
+				ui.Close()
+				fail(fmt.Errorf("usage: twistr replay NAME"))
+			}
+			var replay *twistr.ReplayMatch
+			if replay, err = twistr.NewReplayMatch(ui, args[0]); err != nil {
+				ui.Close()
+				fail(err)
+			}
+			match = replay
+		case cmd == "join" || cmd == "" && !isServer(ui):
+			guest := twistr.NewGuestMatch(ui)
+			guest.Addr = opts.addr
+			guest.Port = opts.port
+			match = guest
+		default:
+			name := resume
+			if name == "" && opts.side == "" {
+				name, side = chooseGame(ui)
+			} else if name == "" {
+				name = chooseName(ui)
+			} else if opts.side == "" {
+				if sg, err := twistr.FindGame(name); err == nil {
+					side = resumeSide(ui, sg)
+				} else {
+					side = choosePlayer(ui)
+				}
+			}
+			if cmd == "bot" {
+				match = twistr.NewBotMatch(ui, name, side, opts.kind, bot)
+				break
+			}
+			host := twistr.NewHostMatch(ui, name, side)
+			host.Port = opts.port
+			match = host
+		}
+		return nil
+	}()
+	if err == io.EOF {
+		log.Println("End of input, exiting")
+		ui.Close()
+		return
 	}
 
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, os.Interrupt, os.Kill)
-	done := make(chan int, 1)
-	// This goroutine cleans up
+	// This goroutine cleans up on a signal
 	go func() {
-		exitcode := <-done
+		<-sigs
 		ui.Close()
 		match.Close()
-		os.Exit(exitcode)
+		os.Exit(1)
 	}()
-	// This one forwards the signal to the cleanup routine
-	go func() {
-		<-sigs
-		done <- 1
-	}()
-	// This one forwards the signal during a normal exit
-	defer func() {
-		done <- 0
-	}()
-	match.Run()
+	err = match.Run()
+	ui.Close()
+	match.Close()
+	if err == io.EOF {
+		// No one is left to answer.
+		log.Println("End of input, exiting")
+		return
+	}
+	if err != nil {
+		log.Println(err.Error())
+		fail(err)
+	}
 }
@@ -0,0 +1,385 @@
+package twistr
+
+import "bufio"
+import "bytes"
+import "crypto/hmac"
+import "crypto/rand"
+import "crypto/sha256"
+import "encoding/hex"
+import "fmt"
+import "hash"
+import "io"
+import "io/ioutil"
+import "os"
+import "strconv"
+import "strings"
+import "time"
+
+// The AOF is a list of input lines, written a block at a time as the game
+// commits. Each block is followed by a chain line carrying a hash of the block
+// and the previous chain hash, so a block that is cut short, garbled or edited
+// by hand breaks every hash after it. From format 2 the hash is an HMAC keyed
+// with the game's secret, which the host makes when the game starts and hands
+// the guest each time it connects. The secret is kept in a key file beside
+// the AOF rather than in it, so an AOF can't be edited and sealed again
+// without the key file too. Either player holds the key, so what catches a
+// player's own edits is the guest keeping its own copy, which the host's must
+// agree with; see GuestMatch.openOwnAof.
+const chainPrefix = controlPrefix + "CHAIN "
+
+// chainHash hashes block onto the chain hash prev, keyed with key unless it is
+// nil, as it is for AOFs from before format 2.
+func chainHash(key []byte, prev, block string) string {
+	var h hash.Hash
+	if key == nil {
+		h = sha256.New()
+	} else {
+		h = hmac.New(sha256.New, key)
+	}
+	io.WriteString(h, prev+"\n"+block)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ChainWriter seals each block written to the AOF with its chain line.
+type ChainWriter struct {
+	w   io.Writer
+	key []byte
+	// Hash of the last block written.
+	Last string
+}
+
+func NewChainWriter(w io.Writer, last string, key []byte) *ChainWriter {
+	return &ChainWriter{w: w, key: key, Last: last}
+}
+
+func (c *ChainWriter) Write(block []byte) (n int, err error) {
+	body := strings.TrimRight(string(block), "\n")
+	if len(body) == 0 {
+		return len(block), nil
+	}
+	c.Last = chainHash(c.key, c.Last, body)
+	if _, err = fmt.Fprintf(c.w, "%s\n%s%s\n", body, chainPrefix, c.Last); err != nil {
+		return
+	}
+	return len(block), nil
+}
+
+// unchain verifies the chain lines in an AOF from line from onwards, starting
+// from the chain hash prev, and returns its inputs along with the hash of the last
+// block. Snapshot lines are sealed like the rest but are not inputs. AOFs
+// written before chaining have no chain lines at all; they are accepted as-is.
+func unchain(lines []string, from int, prev string, key []byte) (inputs []string, last string, err error) {
+	inputs = []string{}
+	block := []string{}
+	last = prev
+	chained := prev != ""
+	for i := from; i < len(lines); i++ {
+		line := lines[i]
+		if !strings.HasPrefix(line, chainPrefix) {
+			if line != "" {
+				block = append(block, line)
+			}
+			continue
+		}
+		chained = true
+		expected := chainHash(key, last, strings.Join(block, "\n"))
+		if got := strings.TrimPrefix(line, chainPrefix); got != expected {
+			return nil, "", fmt.Errorf("AOF chain broken at line %d", i+1)
+		}
+		last = expected
+		inputs = append(inputs, withoutSnapLines(block)...)
+		block = []string{}
+	}
+	if len(block) > 0 {
+		if chained {
+			return nil, "", fmt.Errorf("AOF ends with %d unsealed inputs", len(block))
+		}
+		warnf("AOF has no chain; accepting it unverified")
+		inputs = append(inputs, withoutSnapLines(block)...)
+	}
+	return inputs, last, nil
+}
+
+// AofFormat is the version of the AOF layout written by this twistr. AOFs
+// without a header are format 0; format 1 AOFs have an unkeyed chain.
+const AofFormat = 2
+
+// Version of twistr, recorded in the AOFs it creates.
+const Version = "0.1"
+
+const (
+	formatPrefix = controlPrefix + "AOF "
+	metaPrefix   = controlPrefix + "META "
+)
+
+// AofHeader describes the game an AOF holds. It is the first block of the
+// AOF, sealed into the chain like any other, and can be read without
+// replaying the game.
+type AofHeader struct {
+	Format  int
+	Created time.Time
+	Host    Aff
+	// Player names, indexed by side.
+	Players  [2]string
+	Optional bool
+	Scenario string
+	// Extra influence the US bid for the USSR side.
+	Bid     int
+	Version string
+	// Where the random inputs came from; see RNGLive and RNGImported.
+	RNG string
+	// The header's lines as read, so it can be copied exactly.
+	raw []string
+}
+
+const (
+	// Dice rolled and decks shuffled by the players' twistrs.
+	RNGLive = "random"
+	// Dice and shuffles read from another client's log.
+	RNGImported = "imported"
+)
+
+// NewAofHeader describes a new game. Every game is the standard game with the
+// optional cards and no bid, until twistr can set up any other.
+func NewAofHeader(host Aff, players [2]string, rng string) *AofHeader {
+	return &AofHeader{
+		Format:   AofFormat,
+		Created:  time.Now().UTC(),
+		Host:     host,
+		Players:  players,
+		Optional: true,
+		Scenario: "standard",
+		Bid:      0,
+		Version:  Version,
+		RNG:      rng,
+	}
+}
+
+func (h *AofHeader) Lines() []string {
+	if h.raw != nil {
+		return h.raw
+	}
+	meta := func(key string, value interface{}) string {
+		return fmt.Sprintf("%s%s %v", metaPrefix, key, value)
+	}
+	return []string{
+		fmt.Sprintf("%s%d", formatPrefix, h.Format),
+		meta("created", h.Created.Format(time.RFC3339)),
+		meta("host", h.Host.Ref()),
+		meta(USA.Ref(), h.Players[USA]),
+		meta(SOV.Ref(), h.Players[SOV]),
+		meta("optional", h.Optional),
+		meta("scenario", h.Scenario),
+		meta("bid", h.Bid),
+		meta("version", h.Version),
+		meta("rng", h.RNG),
+	}
+}
+
+func parseAofHeader(lines []string) (*AofHeader, error) {
+	h := &AofHeader{raw: lines}
+	var err error
+	if h.Format, err = strconv.Atoi(strings.TrimPrefix(lines[0], formatPrefix)); err != nil {
+		return nil, fmt.Errorf("Bad AOF format '%s'", lines[0])
+	}
+	if h.Format > AofFormat {
+		return nil, fmt.Errorf("AOF format %d is newer than this twistr supports", h.Format)
+	}
+	for _, line := range lines[1:] {
+		fields := strings.Fields(strings.TrimPrefix(line, metaPrefix))
+		if !strings.HasPrefix(line, metaPrefix) || len(fields) == 0 {
+			return nil, fmt.Errorf("Bad AOF header line '%s'", line)
+		}
+		key, value := fields[0], strings.Join(fields[1:], " ")
+		switch key {
+		case "created":
+			h.Created, err = time.Parse(time.RFC3339, value)
+		case "host":
+			err = Unmarshal(value, &h.Host)
+		case USA.Ref():
+			h.Players[USA] = value
+		case SOV.Ref():
+			h.Players[SOV] = value
+		case "optional":
+			h.Optional, err = strconv.ParseBool(value)
+		case "scenario":
+			h.Scenario = value
+		case "bid":
+			h.Bid, err = strconv.Atoi(value)
+		case "version":
+			h.Version = value
+		case "rng":
+			h.RNG = value
+		default:
+			// Written by a later twistr; keep it in raw but otherwise ignore it.
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Bad AOF header line '%s': %s", line, err.Error())
+		}
+	}
+	return h, nil
+}
+
+// chainKey is the key the AOF's chain is keyed with, given the game's key:
+// none for AOFs from before format 2.
+func (h *AofHeader) chainKey(key []byte) []byte {
+	if h == nil || h.Format < 2 {
+		return nil
+	}
+	return key
+}
+
+// KeyPath is where the key of the AOF at aofPath is kept.
+func KeyPath(aofPath string) string {
+	return strings.TrimSuffix(aofPath, ".aof") + ".key"
+}
+
+// newAofKey makes the secret for a new game's AOF.
+func newAofKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// readAofKey reads the key of the AOF at aofPath, which is nil if it has none.
+func readAofKey(aofPath string) ([]byte, error) {
+	b, err := ioutil.ReadFile(KeyPath(aofPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimSpace(string(b)))
+}
+
+func writeAofKey(aofPath string, key []byte) error {
+	return ioutil.WriteFile(KeyPath(aofPath), []byte(hex.EncodeToString(key)+"\n"), 0600)
+}
+
+// parseAof verifies an AOF, keyed with key, and splits it into its header, its
+// inputs and the hash of its last block. A format 0 AOF has no header, and a
+// nil header is returned for it.
+func parseAof(lines []string, key []byte) (header *AofHeader, inputs []string, last string, err error) {
+	start := 0
+	for start < len(lines) && lines[start] == "" {
+		start++
+	}
+	if start == len(lines) || !strings.HasPrefix(lines[start], formatPrefix) {
+		inputs, last, err = unchain(lines, 0, "", nil)
+		return nil, inputs, last, err
+	}
+	end := start
+	for end < len(lines) && !strings.HasPrefix(lines[end], chainPrefix) {
+		end++
+	}
+	if end == len(lines) {
+		return nil, nil, "", fmt.Errorf("AOF header is not sealed")
+	}
+	if header, err = parseAofHeader(lines[start:end]); err != nil {
+		return nil, nil, "", err
+	}
+	key = header.chainKey(key)
+	if header.Format >= 2 && key == nil {
+		return nil, nil, "", fmt.Errorf("AOF is keyed, and its key is missing")
+	}
+	last = chainHash(key, "", strings.Join(header.Lines(), "\n"))
+	if strings.TrimPrefix(lines[end], chainPrefix) != last {
+		return nil, nil, "", fmt.Errorf("AOF chain broken at line %d", end+1)
+	}
+	inputs, last, err = unchain(lines, end+1, last, key)
+	return header, inputs, last, err
+}
+
+// ReadAofHeader reads just the header of the AOF at path, for listing games
+// without replaying them. The header of a format 0 AOF is nil.
+func ReadAofHeader(path string) (*AofHeader, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	scanner := bufio.NewScanner(in)
+	lines := []string{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, chainPrefix) {
+			break
+		}
+		if len(lines) == 0 && !strings.HasPrefix(line, formatPrefix) {
+			return nil, scanner.Err()
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	return parseAofHeader(lines)
+}
+
+// ValidateAof verifies the AOF at path, with the key kept beside it,
+// returning its header and inputs.
+func ValidateAof(path string) (*AofHeader, []string, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, nil, err
+	}
+	lines, err := loadAof(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := readAofKey(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	header, inputs, _, err := parseAof(lines, key)
+	return header, inputs, err
+}
+
+// writeAof writes a fresh AOF holding header and inputs, sealing the inputs
+// as a single block, and returns the hash of the last block. It is used to
+// start new AOFs and to migrate old ones. The chain is keyed with key, which
+// is written beside the AOF.
+func writeAof(path string, header *AofHeader, key []byte, inputs []string) (string, error) {
+	key = header.chainKey(key)
+	if key != nil {
+		if err := writeAofKey(path, key); err != nil {
+			return "", err
+		}
+	}
+	b := new(bytes.Buffer)
+	aof := NewChainWriter(b, "", key)
+	if _, err := aof.Write([]byte(strings.Join(header.Lines(), "\n"))); err != nil {
+		return "", err
+	}
+	if _, err := aof.Write([]byte(strings.Join(inputs, "\n"))); err != nil {
+		return "", err
+	}
+	return aof.Last, ioutil.WriteFile(path, b.Bytes(), 0666)
+}
+
+// MigrateAof upgrades a format 0 AOF at path to the current format, filling
+// in the header from what is known. The original is kept alongside it.
+func MigrateAof(path string, header *AofHeader) error {
+	old, inputs, err := ValidateAof(path)
+	if err != nil || old != nil {
+		return err
+	}
+	key, err := newAofKey()
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(path); err == nil {
+		header.Created = fi.ModTime().UTC()
+	}
+	infof("Migrating %s to AOF format %d\n", path, header.Format)
+	if err := os.Rename(path, path+".v0"); err != nil {
+		return err
+	}
+	_, err = writeAof(path, header, key, inputs)
+	return err
+}
@@ -0,0 +1,124 @@
+package twistr
+
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "reflect"
+import "strings"
+import "testing"
+import "time"
+
+func TestAofHeaderRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "game.aof")
+	header := NewAofHeader(SOV, [2]string{"alice", "bob"}, RNGLive)
+	// The header keeps the time to the second.
+	header.Created = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	inputs := []string{"usa", "westgermany", "coup"}
+	if _, err := writeAof(path, header, []byte("secret"), inputs); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadAofHeader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read.raw = nil
+	if !reflect.DeepEqual(read, header) {
+		t.Errorf("Read header %+v, want %+v", read, header)
+	}
+	if !read.Optional || read.Scenario != "standard" || read.Bid != 0 {
+		t.Errorf("Header has optional %v, scenario '%s', bid %d", read.Optional, read.Scenario, read.Bid)
+	}
+	_, got, err := ValidateAof(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, inputs) {
+		t.Errorf("Got inputs %v, want %v", got, inputs)
+	}
+}
+
+func TestMigrateAof(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "game.aof")
+	inputs := []string{"usa", "westgermany", "coup"}
+	if err := ioutil.WriteFile(path, []byte(strings.Join(inputs, "\n")+"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if header, err := ReadAofHeader(path); err != nil || header != nil {
+		t.Fatalf("Header-less AOF read as %v, %v", header, err)
+	}
+	if err := MigrateAof(path, NewAofHeader(USA, [2]string{"alice", "bob"}, RNGLive)); err != nil {
+		t.Fatal(err)
+	}
+	header, got, err := ValidateAof(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header == nil || header.Format != AofFormat || header.Players[SOV] != "bob" {
+		t.Errorf("Migrated header %+v", header)
+	}
+	if !reflect.DeepEqual(got, inputs) {
+		t.Errorf("Got inputs %v, want %v", got, inputs)
+	}
+	if _, err := os.Stat(path + ".v0"); err != nil {
+		t.Errorf("Original not kept: %s", err)
+	}
+	// A migrated AOF is left alone.
+	before, _ := ioutil.ReadFile(path)
+	if err := MigrateAof(path, NewAofHeader(SOV, [2]string{}, RNGLive)); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := ioutil.ReadFile(path); string(after) != string(before) {
+		t.Error("Migrated a second time")
+	}
+}
+
+func TestAofChainCatchesEdits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "game.aof")
+	header := NewAofHeader(USA, [2]string{"alice", "bob"}, RNGLive)
+	if _, err := writeAof(path, header, []byte("secret"), []string{"usa", "westgermany", "coup"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ValidateAof(path); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited := strings.Replace(string(b), "westgermany", "france", 1)
+	if err := ioutil.WriteFile(path, []byte(edited), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ValidateAof(path); err == nil {
+		t.Error("Validated an edited AOF")
+	}
+	// Sealed again by someone without the key.
+	lines := strings.Split(strings.TrimRight(edited, "\n"), "\n")
+	last := ""
+	block := []string{}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, chainPrefix) {
+			block = append(block, line)
+			continue
+		}
+		last = chainHash(nil, last, strings.Join(block, "\n"))
+		lines[i] = chainPrefix + last
+		block = []string{}
+	}
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ValidateAof(path); err == nil {
+		t.Error("Validated an AOF sealed again without the key")
+	}
+	// Without its key, not even the AOF as written validates.
+	if err := ioutil.WriteFile(path, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(KeyPath(path)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ValidateAof(path); err == nil {
+		t.Error("Validated a keyed AOF without its key")
+	}
+}
@@ -0,0 +1,113 @@
+package twistr
+
+import "fmt"
+import "io/ioutil"
+import "math/rand"
+import "sort"
+import "strings"
+
+// A BotMaker makes a bot to play one game. Bots that play at random draw from
+// seed, and bots that search think within budget.
+type BotMaker func(seed int64, budget Budget) Agent
+
+// The kinds of bot, by name; see RegisterBot.
+var botMakers = map[string]BotMaker{
+	"random": func(seed int64, budget Budget) Agent {
+		return NewRandomBot(seed)
+	},
+	"heuristic": func(seed int64, budget Budget) Agent {
+		return HeuristicBot{}
+	},
+	"mcts": func(seed int64, budget Budget) Agent {
+		return NewMCTSBot(budget, seed)
+	},
+}
+
+// RegisterBot adds a kind of bot, to be played against and put in
+// tournaments. Register bots before playing any.
+func RegisterBot(kind string, maker BotMaker) {
+	botMakers[kind] = maker
+}
+
+// BotKinds returns the names of the kinds of bot.
+func BotKinds() []string {
+	kinds := []string{}
+	for kind := range botMakers {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// NewBot makes a bot of the given kind.
+func NewBot(kind string, seed int64, budget Budget) (Agent, error) {
+	maker, ok := botMakers[kind]
+	if !ok {
+		return nil, fmt.Errorf("No bot of kind '%s'; there are %s", kind, strings.Join(BotKinds(), ", "))
+	}
+	return maker(seed, budget), nil
+}
+
+// RandomBot answers every decision with one of its legal answers, chosen
+// uniformly at random. It's no opponent, but it plays by the rules, which
+// is enough for testing them.
+type RandomBot struct {
+	rng *rand.Rand
+}
+
+func NewRandomBot(seed int64) *RandomBot {
+	return &RandomBot{rng: rand.New(rand.NewSource(seed))}
+}
+
+func (b *RandomBot) Decide(s *State, d *Decision) string {
+	if d.Random != nil {
+		return d.Auto()
+	}
+	if legal := d.Legal(s); legal != nil {
+		return legal[b.rng.Intn(len(legal))]
+	}
+	// A list: take each item with even odds.
+	answer := "[ "
+	for _, m := range d.Members(s) {
+		if b.rng.Intn(2) == 0 {
+			answer += m + " "
+		}
+	}
+	answer += "]"
+	if d.Check(answer) != nil {
+		answer = "[ ]"
+	}
+	return answer
+}
+
+// BotMatch is a game against a bot, played in-process. The bot answers the
+// other side's decisions, so there is no peer and no link.
+type BotMatch struct {
+	*Match
+	Bot Agent
+}
+
+func NewBotMatch(ui UI, name string, who Aff, kind string, bot Agent) *BotMatch {
+	m := NewMatch(ui)
+	m.Name = name
+	m.Who = who
+	m.Opponent = kind + "-bot"
+	return &BotMatch{Match: m, Bot: bot}
+}
+
+func (b *BotMatch) Run() error {
+	b.openLog(b.LogPath())
+	if err := b.prepareAof(); err != nil {
+		return err
+	}
+	lines, err := loadAof(b.AofPath())
+	if err != nil {
+		return err
+	}
+	if err := b.resume(lines); err != nil {
+		return err
+	}
+	b.State.LinkOut = NewCmdOut(ioutil.Discard)
+	b.State.Agents[b.Who.Opp()] = b.Bot
+	return b.Match.Start()
+}
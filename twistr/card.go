@@ -1,5 +1,7 @@
 package twistr
 
+import "math/rand"
+
 type Card struct {
 	Id   CardId
 	Aff  Aff
@@ -96,9 +98,9 @@ func NewDeck() *Deck {
 
 // Shuffle does not modify the deck in place, but rather returns the new order
 // of its cards. Use Reorder to change the deck's order.
-func (d *Deck) Shuffle() []Card {
+func (d *Deck) Shuffle(r *rand.Rand) []Card {
 	order := make([]Card, len(d.Cards))
-	for i, j := range rng.Perm(len(d.Cards)) {
+	for i, j := range r.Perm(len(d.Cards)) {
 		order[i] = d.Cards[j]
 	}
 	return order
@@ -127,6 +129,15 @@ func (d *Deck) Remove(card Card) {
 	}
 }
 
+func (d *Deck) Contains(card Card) bool {
+	for _, c := range d.Cards {
+		if c.Equal(card) {
+			return true
+		}
+	}
+	return false
+}
+
 func (d *Deck) Push(cards ...Card) {
 	d.Cards = append(d.Cards, cards...)
 }
@@ -39,7 +39,7 @@ func (a Aff) Ref() string {
 // lookupAff expects the incoming string to be lowercase.
 func lookupAff(player string) (Aff, error) {
 	switch player {
-	case "usa":
+	case "usa", "us":
 		return USA, nil
 	case "ussr":
 		return SOV, nil
@@ -73,6 +73,29 @@ const (
 	ViewOpponentHand
 )
 
+func (a Ability) Ref() string {
+	switch a {
+	case ViewDiscard:
+		return "viewdiscard"
+	case ViewOpponentHand:
+		return "viewopponenthand"
+	default:
+		return "?"
+	}
+}
+
+// lookupAbility expects the incoming string to be lowercase.
+func lookupAbility(name string) (Ability, error) {
+	switch name {
+	case "viewdiscard":
+		return ViewDiscard, nil
+	case "viewopponenthand":
+		return ViewOpponentHand, nil
+	default:
+		return Zilch, errors.New("Bad ability '" + name + "'")
+	}
+}
+
 func (a Ability) Message() string {
 	switch a {
 	case ViewDiscard:
@@ -701,6 +724,12 @@ func lookupOpsKind(name string) (OpsKind, error) {
 
 // lookupCountry expects the incoming string to be lowercase.
 func lookupCountry(name string) (*Country, error) {
+	return lookupCountryIn(Countries, name)
+}
+
+// lookupCountryIn looks the country named up among countries, such as a
+// game's own.
+func lookupCountryIn(countries map[CountryId]*Country, name string) (*Country, error) {
 	var cid CountryId
 	var ok bool
 	if strings.ToLower(name) == EndSelectCountryStr {
@@ -711,7 +740,7 @@ func lookupCountry(name string) (*Country, error) {
 			return nil, errors.New("Unknown country '" + name + "'")
 		}
 	}
-	return Countries[cid], nil
+	return countries[cid], nil
 }
 
 // lookupCard expects the incoming string to be lowercase.
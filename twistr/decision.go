@@ -0,0 +1,141 @@
+package twistr
+
+import "fmt"
+
+// A Decision is a point where the game needs an answer from a player: which
+// card, which way to play it, which country. The engine makes a decision and
+// the Agent for that side answers it.
+type Decision struct {
+	Prompt
+	// The answers allowed, as logged, if the engine can list them.
+	Choices []string
+	// For the decisions on how to play a card and what to do with its ops,
+	// the card and the ops it's worth.
+	Card Card
+	Ops  int
+	// Set for decisions left to chance, such as dice and shuffles; it makes
+	// the decision into the value read into.
+	Random func()
+	// What the answer is read into, and any further check on it.
+	thing interface{}
+	check inputCheck
+	// If set, applies the answer read into thing to the game, for previews.
+	effect func()
+	// If set, describes the odds of a coup or realignment in a country.
+	odds func(*Country) []string
+	// The game's countries, which the countries an answer names are read as.
+	countries map[CountryId]*Country
+}
+
+// Check reports whether answer, as it would be logged, is legal. It leaves the
+// answer read into the value the engine reads the decision into.
+func (d *Decision) Check(answer string) error {
+	return checkInput(answer, d.thing, inChoices(d.thing, d.Choices, d.check), d.countries)
+}
+
+// Auto makes a random decision, returning the answer.
+func (d *Decision) Auto() string {
+	d.Random()
+	b, err := Marshal(d.thing)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
+
+// An Agent answers the decisions of one side: a human at the UI, the peer at
+// the other end of the link, or a bot.
+type Agent interface {
+	Decide(s *State, d *Decision) string
+}
+
+// Human answers decisions through the UI, leaving decisions to chance to
+// the computer.
+type Human struct{}
+
+func (Human) Decide(s *State, d *Decision) string {
+	if d.Random != nil {
+		return d.Auto()
+	}
+	message := d.Message
+	if d.odds != nil {
+		message += " ('odds' for the odds)"
+	}
+	for {
+		answer := Solicit(s.UI, message, d.Choices)
+		if modal(s, answer) {
+			continue
+		}
+		if err := d.Check(answer); err != nil {
+			message = err.Error() + ". Try again?"
+			continue
+		}
+		return answer
+	}
+}
+
+// Peer answers decisions for the side played at the other end of the link.
+// An illegal answer from the peer halts the game rather than being applied.
+type Peer struct{}
+
+func (Peer) Decide(s *State, d *Decision) string {
+	// Autocommit to preclude deadlock
+	s.flush()
+	line := s.WaitRemote()
+	if err := d.Check(line); err != nil {
+		s.RemoteViolation(line, err)
+	}
+	return line
+}
+
+// agent returns who answers player's decisions. Unless set otherwise, the
+// local player answers through the UI and the other side over the link;
+// neutral decisions, like shuffles, are the server's.
+func (s *State) agent(player Aff) Agent {
+	if player == NEU {
+		if s.Server {
+			player = s.LocalPlayer
+		} else {
+			player = s.LocalPlayer.Opp()
+		}
+	}
+	if s.Agents[player] != nil {
+		return s.Agents[player]
+	}
+	if player == s.LocalPlayer {
+		return Human{}
+	}
+	return Peer{}
+}
+
+// Decide gets the answer to a decision. History is tried first. If that's
+// exhausted, the side's agent answers. Answers not replayed are written to
+// history, and those not from the peer are sent to it.
+// See State.ReadInto, .Log
+func (s *State) Decide(d *Decision) {
+	d.countries = s.Countries
+	if s.previewing != nil {
+		s.previewing.decide(s, d)
+		return
+	}
+	debugf("input", "Reading from %s '%s'\n", d.Player, d.Message)
+	s.Asking = d
+	if s.ReadInto(d) {
+		return
+	}
+	s.History.Replaying = false
+	agent := s.agent(d.Player)
+	answer := agent.Decide(s, d)
+	if err := d.Check(answer); err != nil {
+		// Agents only give legal answers, so this is a bug in the agent.
+		panic(fmt.Sprintf("%s answered '%s' to '%s': %s", d.Player, answer, d.Message, err.Error()))
+	}
+	if _, ok := agent.(Peer); ok {
+		debugf("input", "Read %s in from remote. Writing to history\n", answer)
+		if _, err := s.History.Write([]byte(answer)); err != nil {
+			errorf("%s", err)
+		}
+		return
+	}
+	s.Log(d.thing)
+}
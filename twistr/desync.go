@@ -0,0 +1,336 @@
+package twistr
+
+import "bytes"
+import "crypto/sha256"
+import "encoding/hex"
+import "fmt"
+import "io/ioutil"
+import "sort"
+import "strconv"
+import "strings"
+import "time"
+
+// Dump returns a canonical, human-readable description of the game. Peers
+// running the same inputs produce identical dumps, so the dump doubles as the
+// input to Hash. The transcript and turn abilities are left out; abilities
+// are only ever enabled for the local player, so they legitimately differ.
+func (g *Game) Dump() string {
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "turn %d\n", g.Turn)
+	fmt.Fprintf(b, "ar %d\n", g.AR)
+	fmt.Fprintf(b, "phasing %s\n", g.Phasing.Ref())
+	fmt.Fprintf(b, "vp %d\n", g.VP)
+	fmt.Fprintf(b, "defcon %d\n", g.Defcon)
+	fmt.Fprintf(b, "milops %d %d\n", g.MilOps[USA], g.MilOps[SOV])
+	fmt.Fprintf(b, "spacerace %d %d\n", g.SpaceRace[USA], g.SpaceRace[SOV])
+	fmt.Fprintf(b, "spaceattempts %d %d\n", g.SpaceAttempts[USA], g.SpaceAttempts[SOV])
+	fmt.Fprintf(b, "china %s %v\n", g.ChinaCardPlayer.Ref(), g.ChinaCardFaceUp)
+	fmt.Fprintf(b, "chernobyl %s\n", g.ChernobylRegion.Ref())
+	dumpEvents(b, "event", g.Events)
+	dumpEvents(b, "turnevent", g.TurnEvents)
+	srIds := []int{}
+	for id := range g.SREvents {
+		srIds = append(srIds, int(id))
+	}
+	sort.Ints(srIds)
+	for _, id := range srIds {
+		fmt.Fprintf(b, "srevent %d %s\n", id, g.SREvents[SpaceId(id)].Ref())
+	}
+	dumpDeck(b, "deck", g.Deck)
+	dumpDeck(b, "discard", g.Discard)
+	dumpDeck(b, "removed", g.Removed)
+	dumpDeck(b, "hand us", g.Hands[USA])
+	dumpDeck(b, "hand ussr", g.Hands[SOV])
+	countryIds := []int{}
+	for id := range g.Countries {
+		countryIds = append(countryIds, int(id))
+	}
+	sort.Ints(countryIds)
+	for _, id := range countryIds {
+		c := g.Countries[CountryId(id)]
+		fmt.Fprintf(b, "country %s %d %d\n", c.Ref(), c.Inf[USA], c.Inf[SOV])
+	}
+	return b.String()
+}
+
+func dumpEvents(b *bytes.Buffer, label string, events map[CardId]Aff) {
+	ids := []int{}
+	for id := range events {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		fmt.Fprintf(b, "%s %s %s\n", label, Cards[CardId(id)].Ref(), events[CardId(id)].Ref())
+	}
+}
+
+func dumpDeck(b *bytes.Buffer, label string, d *Deck) {
+	refs := make([]string, len(d.Cards))
+	for i, c := range d.Cards {
+		refs[i] = c.Ref()
+	}
+	fmt.Fprintf(b, "%s [ %s ]\n", label, strings.Join(refs, " "))
+}
+
+// Hash returns a digest of the game's Dump.
+func (g *Game) Hash() string {
+	return hashDump(g.Dump())
+}
+
+func hashDump(dump string) string {
+	sum := sha256.Sum256([]byte(dump))
+	return hex.EncodeToString(sum[:])
+}
+
+// Checkpoints number every commit in the game. Commits happen at the same
+// points in the game for both peers, so the game state at each numbered
+// commit can be compared between them.
+type checkpoints struct {
+	n      int
+	local  map[int]string
+	remote map[int]string
+}
+
+func newCheckpoints() *checkpoints {
+	return &checkpoints{
+		n:      0,
+		local:  make(map[int]string),
+		remote: make(map[int]string),
+	}
+}
+
+// checkpoint numbers the next commit, and records the game state there to
+// compare with the peer's. Without a peer there is nothing to compare it
+// with, and nothing is kept.
+func (s *State) checkpoint() int {
+	s.checkpoints.n++
+	if s.LinkIn != nil {
+		s.checkpoints.local[s.checkpoints.n] = s.Game.Dump()
+	}
+	return s.checkpoints.n
+}
+
+// readControl handles any control lines the peer has sent, without blocking.
+func (s *State) readControl() {
+	if s.LinkIn == nil {
+		return
+	}
+	for {
+		select {
+		case line := <-s.LinkIn.Control:
+			s.control(line)
+		default:
+			return
+		}
+	}
+}
+
+func (s *State) control(line string) {
+	fields := strings.Fields(strings.TrimPrefix(line, controlPrefix))
+	if len(fields) != 3 || fields[0] != "HASH" {
+		warnf("Ignoring control line '%s'\n", line)
+		return
+	}
+	k, err := strconv.Atoi(fields[1])
+	if err != nil {
+		warnf("Bad hash line '%s': %s\n", line, err.Error())
+		return
+	}
+	s.checkpoints.remote[k] = fields[2]
+	s.verify(k)
+}
+
+// verify compares the local and peer hashes for commit k, once both are known.
+func (s *State) verify(k int) {
+	local, ok := s.checkpoints.local[k]
+	if !ok {
+		return
+	}
+	remote, ok := s.checkpoints.remote[k]
+	if !ok {
+		return
+	}
+	if hashDump(local) != remote {
+		s.Desync(k)
+	}
+	// Older checkpoints can never be compared now.
+	for i := range s.checkpoints.local {
+		if i <= k {
+			delete(s.checkpoints.local, i)
+		}
+	}
+	for i := range s.checkpoints.remote {
+		if i <= k {
+			delete(s.checkpoints.remote, i)
+		}
+	}
+}
+
+// Desync halts the game after the peers disagree about the game state at
+// commit k. Each peer sends its dump to the other, and writes both to
+// DesyncPath.
+func (s *State) Desync(k int) {
+	local := s.checkpoints.local[k]
+	msg := fmt.Sprintf("Desync detected at commit %d!", k)
+	errorf("%s", msg)
+	s.LinkOut.Send(fmt.Sprintf("%sDESYNC %d", controlPrefix, k))
+	for _, line := range strings.Split(strings.TrimRight(local, "\n"), "\n") {
+		s.LinkOut.Send(controlPrefix + "DUMP " + line)
+	}
+	s.LinkOut.Send(controlPrefix + "END DESYNC")
+	peer := s.awaitPeerDump(10 * time.Second)
+	if s.DesyncPath != "" {
+		b := new(bytes.Buffer)
+		fmt.Fprintf(b, "Desync at commit %d\n", k)
+		fmt.Fprintf(b, "local hash: %s\n", hashDump(local))
+		fmt.Fprintf(b, "peer hash:  %s\n", s.checkpoints.remote[k])
+		fmt.Fprintf(b, "\n== Local (%s)\n%s", s.LocalPlayer, local)
+		fmt.Fprintf(b, "\n== Peer (%s)\n%s", s.LocalPlayer.Opp(), peer)
+		if err := ioutil.WriteFile(s.DesyncPath, b.Bytes(), 0666); err != nil {
+			errorf("Failed to write desync dump: %s\n", err.Error())
+		} else {
+			msg = fmt.Sprintf("%s Dump written to %s.", msg, s.DesyncPath)
+		}
+	}
+	s.Halt(msg)
+}
+
+// awaitPeerDump collects the dump the peer sends once it detects the same
+// desync.
+func (s *State) awaitPeerDump(timeout time.Duration) string {
+	if s.LinkIn == nil {
+		return "(not received)\n"
+	}
+	b := new(bytes.Buffer)
+	deadline := time.After(timeout)
+	receiving := false
+	for {
+		select {
+		case line := <-s.LinkIn.Control:
+			switch {
+			case strings.HasPrefix(line, controlPrefix+"DESYNC"):
+				receiving = true
+			case line == controlPrefix+"END DESYNC" && receiving:
+				return b.String()
+			case strings.HasPrefix(line, controlPrefix+"DUMP ") && receiving:
+				b.WriteString(strings.TrimPrefix(line, controlPrefix+"DUMP "))
+				b.WriteString("\n")
+			}
+		case <-deadline:
+			warnf("Timed out waiting for the peer's dump")
+			return "(not received)\n"
+		}
+	}
+}
+
+// LoadDump sets the game to the state described by dump, as written by Dump.
+// The game's countries and decks are updated in place, so a preview can put
+// the game back without swapping out decks that others still hold.
+func (g *Game) LoadDump(dump string) (err error) {
+	g.Events = make(map[CardId]Aff)
+	g.TurnEvents = make(map[CardId]Aff)
+	g.SREvents = make(map[SpaceId]Aff)
+	g.CancelTurnAbilities()
+	affs := func(words []string, into ...*Aff) error {
+		for i, word := range words {
+			if err := Unmarshal(word, into[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	ints := func(words []string, into ...*int) error {
+		for i, word := range words {
+			if *into[i], err = strconv.Atoi(word); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	deck := func(into **Deck, rest string) error {
+		cards := []Card{}
+		if err := Unmarshal(rest, &cards); err != nil {
+			return err
+		}
+		if *into == nil {
+			*into = NewDeck()
+		}
+		(*into).Cards = cards
+		return nil
+	}
+	for _, line := range strings.Split(strings.TrimRight(dump, "\n"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		key, args := fields[0], fields[1:]
+		rest := strings.TrimPrefix(line, key+" ")
+		switch key {
+		case "turn":
+			err = ints(args, &g.Turn)
+		case "ar":
+			err = ints(args, &g.AR)
+		case "phasing":
+			err = affs(args, &g.Phasing)
+		case "vp":
+			err = ints(args, &g.VP)
+		case "defcon":
+			err = ints(args, &g.Defcon)
+		case "milops":
+			err = ints(args, &g.MilOps[USA], &g.MilOps[SOV])
+		case "spacerace":
+			err = ints(args, &g.SpaceRace[USA], &g.SpaceRace[SOV])
+		case "spaceattempts":
+			err = ints(args, &g.SpaceAttempts[USA], &g.SpaceAttempts[SOV])
+		case "china":
+			if err = affs(args[:1], &g.ChinaCardPlayer); err == nil && len(args) == 2 {
+				g.ChinaCardFaceUp, err = strconv.ParseBool(args[1])
+			}
+		case "chernobyl":
+			g.ChernobylRegion = Region{}
+			if len(args) > 0 {
+				g.ChernobylRegion, err = lookupRegion(args[0])
+			}
+		case "event", "turnevent":
+			var card Card
+			var aff Aff
+			if err = Unmarshal(args[0], &card); err == nil {
+				err = affs(args[1:], &aff)
+			}
+			if key == "event" {
+				g.Events[card.Id] = aff
+			} else {
+				g.TurnEvents[card.Id] = aff
+			}
+		case "srevent":
+			var id int
+			var aff Aff
+			if err = ints(args[:1], &id); err == nil {
+				err = affs(args[1:], &aff)
+			}
+			g.SREvents[SpaceId(id)] = aff
+		case "deck":
+			err = deck(&g.Deck, rest)
+		case "discard":
+			err = deck(&g.Discard, rest)
+		case "removed":
+			err = deck(&g.Removed, rest)
+		case "hand":
+			var aff Aff
+			if err = affs(args[:1], &aff); err == nil {
+				err = deck(&g.Hands[aff], strings.TrimPrefix(rest, args[0]+" "))
+			}
+		case "country":
+			var c *Country
+			if c, err = lookupCountryIn(g.Countries, args[0]); err == nil {
+				err = ints(args[1:], &c.Inf[USA], &c.Inf[SOV])
+			}
+		default:
+			err = fmt.Errorf("Unknown key '%s'", key)
+		}
+		if err != nil {
+			return fmt.Errorf("Bad dump line '%s': %s", line, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package twistr
+
+import "bufio"
+import "io"
+import "io/ioutil"
+import "path/filepath"
+import "strings"
+import "testing"
+import "time"
+
+func TestCheckpointWithoutPeer(t *testing.T) {
+	s := NewState(NewHistory(NullUI{}), NewGame(), true, USA, ioutil.Discard)
+	s.LinkOut = NewCmdOut(ioutil.Discard)
+	s.Commit()
+	s.Commit()
+	if s.checkpoints.n != 2 {
+		t.Errorf("Got %d commits, want 2", s.checkpoints.n)
+	}
+	if len(s.checkpoints.local) != 0 {
+		t.Errorf("Kept %d dumps with no peer to compare them with", len(s.checkpoints.local))
+	}
+}
+
+// linkedStates returns a host and a guest whose links are joined to each
+// other.
+func linkedStates(dir string) (host, guest *State) {
+	toGuest, fromHost := io.Pipe()
+	toHost, fromGuest := io.Pipe()
+	host = NewState(NewHistory(NullUI{}), NewGame(), true, USA, ioutil.Discard)
+	host.LinkIn = NewCmdIn(bufio.NewScanner(toHost))
+	host.LinkOut = NewCmdOut(fromHost)
+	host.DesyncPath = filepath.Join(dir, "host.desync")
+	guest = NewState(NewHistory(NullUI{}), NewGame(), false, SOV, ioutil.Discard)
+	guest.LinkIn = NewCmdIn(bufio.NewScanner(toGuest))
+	guest.LinkOut = NewCmdOut(fromGuest)
+	guest.DesyncPath = filepath.Join(dir, "guest.desync")
+	return host, guest
+}
+
+func TestDesync(t *testing.T) {
+	dir := t.TempDir()
+	host, guest := linkedStates(dir)
+	guest.Game.VP = 3
+	halts := make(chan interface{}, 2)
+	for _, s := range []*State{host, guest} {
+		go func(s *State) {
+			defer func() { halts <- recover() }()
+			s.Commit()
+			// Wait for the peer's hash of the commit.
+			for len(s.checkpoints.remote) == 0 {
+				time.Sleep(time.Millisecond)
+				s.readControl()
+			}
+		}(s)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case x := <-halts:
+			if _, ok := x.(halted); !ok {
+				t.Errorf("Got %v, want the game halted", x)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the desync")
+		}
+	}
+	for _, s := range []*State{host, guest} {
+		b, err := ioutil.ReadFile(s.DesyncPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dump := string(b)
+		if !strings.Contains(dump, "Desync at commit 1") || !strings.Contains(dump, "vp 3") || !strings.Contains(dump, "vp 0") {
+			t.Errorf("%s holds:\n%s", s.DesyncPath, dump)
+		}
+		if strings.Contains(dump, "(not received)") {
+			t.Errorf("%s lacks the peer's dump", s.DesyncPath)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package twistr
+
+// What winning the game is worth, by Evaluate.
+const evalWin = 1000.0
+
+// The regions scored as a whole. Southeast Asia scores country by country,
+// and is left to its countries.
+var evalRegions = []Region{Europe, Asia, MiddleEast, Africa, CentralAmerica, SouthAmerica}
+
+// Evaluate scores the game for player; more is better, and a VP is worth
+// about 1. It counts the VP, what each region would score now, the
+// battlegrounds controlled, influence towards control, the military
+// operations still owed at the current DEFCON, the danger of a low DEFCON
+// and the cards in player's own hand. It uses nothing player cannot see.
+func Evaluate(g *Game, player Aff) float64 {
+	// From the US side, as the VP track is.
+	v := float64(g.VP)
+	for _, r := range evalRegions {
+		sr := ScoreRegion(g, r)
+		if VPAward(sr.Levels[USA], r) == WIN {
+			v += 20
+		}
+		if VPAward(sr.Levels[SOV], r) == WIN {
+			v -= 20
+		}
+		// Regions score when their cards come, so what they would score now
+		// is only worth part of it.
+		mods := scoreMods(sr, r)
+		v += 0.5 * float64(TotalMod(mods[USA])-TotalMod(mods[SOV]))
+		v += 0.5 * float64(len(sr.Battlegrounds[USA])-len(sr.Battlegrounds[SOV]))
+	}
+	// Summed as whole numbers, so the order of the countries can't change
+	// the total.
+	margins := map[bool]int{}
+	for _, c := range g.Countries {
+		// Influence beyond control is worth little.
+		margins[c.Battleground] += Min(Max(c.Inf[USA]-c.Inf[SOV], -c.Stability-1), c.Stability+1)
+	}
+	v += 0.15*float64(margins[true]) + 0.05*float64(margins[false])
+	// Military operations short at the end of the turn cost VP.
+	v -= 0.5 * float64(Max(g.Defcon-g.MilOps[USA], 0))
+	v += 0.5 * float64(Max(g.Defcon-g.MilOps[SOV], 0))
+	v += 0.5 * float64(g.SpaceRace[USA]-g.SpaceRace[SOV])
+	if player == SOV {
+		v = -v
+	}
+	// At DEFCON 2 a coup in a battleground, or the wrong event, loses the
+	// game.
+	switch g.Defcon {
+	case 2:
+		v -= 3
+	case 3:
+		v -= 1
+	}
+	return v + handValue(g, player)
+}
+
+// handValue scores the cards in player's hand: their own events are an asset,
+// and the opponent's a liability.
+func handValue(g *Game, player Aff) float64 {
+	v := 0.0
+	for _, c := range g.Hands[player].Cards {
+		switch c.Aff {
+		case player:
+			v += 0.25 * float64(c.Ops)
+		case player.Opp():
+			v -= 0.25 * float64(c.Ops)
+		}
+	}
+	if g.ChinaCardPlayer == player {
+		v += 1
+	}
+	return v
+}
+
+// outcomeValue is what the game ending with victor is worth to player.
+func outcomeValue(victor, player Aff) float64 {
+	switch victor {
+	case player:
+		return evalWin
+	case player.Opp():
+		return -evalWin
+	default:
+		return 0
+	}
+}
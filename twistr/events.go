@@ -345,7 +345,7 @@ func PlayUSJapanMutualDefensePact(s *State, player Aff) {
 func PlaySuezCrisis(s *State, player Aff) {
 	/* Remove a total of 4 US Influence from France, the United Kingdom and
 	   Israel (removing no more than 2 Influence per country).  */
-	franceIsraelOrUK := func(c *Country) error {
+	franceIsraelOrUK := func(c *Country, _ []*Country) error {
 		switch c.Id {
 		case France, Israel, UK:
 			return nil
@@ -394,6 +394,12 @@ func PlayUNIntervention(s *State, player Aff) {
 	opponentEvent := func(c Card) bool {
 		return c.Aff == player.Opp()
 	}
+	// Played from the discard, as by Star Wars, there may be no card to play
+	// it with.
+	if !hasInHand(s, player, opponentEvent) {
+		s.Transcribe(fmt.Sprintf("%s has no card with an opponent's event to play.", player))
+		return
+	}
 	card := SelectCard(s, player, opponentEvent, CardBlacklist(TheChinaCard))
 	s.Transcribe(fmt.Sprintf("%s conducts operations with %s.", player, card))
 	s.Hands[player].Remove(card)
@@ -487,7 +493,7 @@ func PlaySpecialRelationship(s *State, player Aff) {
 			InRegion(WestEurope))
 		s.GainVP(USA, 2)
 	case ukControlled:
-		nextToUK := func(c *Country) error {
+		nextToUK := func(c *Country, _ []*Country) error {
 			for _, adj := range c.AdjCountries {
 				if adj.Id == UK {
 					return nil
@@ -520,7 +526,7 @@ func PlayBrushWar(s *State, player Aff) {
 	   country. On a modified die roll of 3-6, the player receives 1 VP and
 	   replaces all the opponent’s Influence in the target country with their
 	   Influence. The player adds 3 to its Military Operations Track. */
-	stabLTE := func(c *Country) error {
+	stabLTE := func(c *Country, _ []*Country) error {
 		if c.Stability > 2 {
 			return fmt.Errorf("Country needs to have stability of 1 or 2")
 		}
@@ -561,20 +567,8 @@ func PlaySoutheastAsiaScoring(s *State, player Aff) {
 	/* 1 VP each for Control of Burma, Cambodia/Laos, Vietnam, Malaysia,
 	   Indonesia and the Philippines. 2 VP for Control of Thailand; MAY NOT BE
 	   HELD! */
-	usaMods := []Mod{}
-	sovMods := []Mod{}
-	for _, c := range SoutheastAsia.Countries {
-		points := 1
-		if c == Thailand {
-			points = 2
-		}
-		switch s.Countries[c].Controlled() {
-		case USA:
-			usaMods = append(usaMods, Mod{points, s.Countries[c].Name})
-		case SOV:
-			sovMods = append(usaMods, Mod{points, s.Countries[c].Name})
-		}
-	}
+	mods := scoreSoutheastAsia(s.Game)
+	usaMods, sovMods := mods[USA], mods[SOV]
 	usaScore := TotalMod(usaMods)
 	sovScore := TotalMod(sovMods)
 	s.Transcribe(fmt.Sprintf("US scores %d: %s.", usaScore, ModSummary(usaMods)))
@@ -647,6 +641,10 @@ func PlaySALTNegotiations(s *State, player Aff) {
 	if "yes" == SelectChoice(s, player,
 		"Choose a card to add to your hand?",
 		"yes", "no") {
+		if !hasDiscarded(s, notScoring) {
+			s.Transcribe("There is no card to take from the discard pile.")
+			return
+		}
 		selected := SelectDiscarded(s, player, notScoring)
 		s.Transcribe(fmt.Sprintf("%s adds %s into their hand from the discard pile.", player, selected))
 		s.Discard.Remove(selected)
@@ -765,6 +763,10 @@ func PlayMissileEnvy(s *State, player Aff) {
 	   immediately. If it contains an opponent’s Event, use the Operations value
 	   (no Event). The opponent must use this card for Operations during their next
 	   action round. */
+	if len(s.Hands[player.Opp()].Cards) == 0 {
+		s.Transcribe(fmt.Sprintf("%s has no card to exchange for Missile Envy.", player.Opp()))
+		return
+	}
 	maxOps := 0
 	for _, c := range s.Hands[player.Opp()].Cards {
 		if c.Ops > maxOps {
@@ -823,7 +825,7 @@ func PlaySouthAfricanUnrest(s *State, player Aff) {
 		plusInf(s, s.Countries[SouthAfrica], SOV, 2)
 	default:
 		plusInf(s, s.Countries[SouthAfrica], SOV, 1)
-		adjToSA := func(c *Country) error {
+		adjToSA := func(c *Country, _ []*Country) error {
 			for _, adj := range s.Countries[SouthAfrica].AdjCountries {
 				if adj.Id == c.Id {
 					return nil
@@ -1084,7 +1086,7 @@ func PlayAskNotWhatYourCountry(s *State, player Aff) {
 	}
 	s.Discard.Push(toDiscard...)
 	s.Transcribe(fmt.Sprintf("US draws %d cards.", toDraw))
-	drawn := s.Deck.Draw(toDraw)
+	drawn := drawCards(s, toDraw)
 	s.Hands[USA].Push(drawn...)
 }
 
@@ -1149,7 +1151,7 @@ func PlayChe(s *State, player Aff) {
 		s.Transcribe("USSR elects not to Coup with Che.")
 		return
 	}
-	notBg := func(c *Country) error {
+	notBg := func(c *Country, _ []*Country) error {
 		if c.Battleground {
 			return fmt.Errorf("%s is a battleground", c.Name)
 		}
@@ -1182,7 +1184,7 @@ func PlayOurManInTehran(s *State, player Aff) {
 		s.Transcribe("US controls no Middle East countries.")
 		return
 	}
-	cards := s.Deck.Draw(5)
+	cards := drawCards(s, 5)
 	// Solicit US player to discard each card
 	toDiscard := SelectSomeCards(s, USA,
 		"Discard which",
@@ -1257,11 +1259,14 @@ func PlayStarWars(s *State, player Aff) {
 	canPlayEvent := func(c Card) bool {
 		return !c.Prevented(s.Game)
 	}
-	card := SelectDiscarded(s, player,
-		canPlayEvent,
-		CardBlacklist(AsiaScoring, EuropeScoring,
-			MiddleEastScoring, CentralAmericaScoring, SouthAmericaScoring,
-			SoutheastAsiaScoring, AfricaScoring))
+	notScoring := CardBlacklist(AsiaScoring, EuropeScoring,
+		MiddleEastScoring, CentralAmericaScoring, SouthAmericaScoring,
+		SoutheastAsiaScoring, AfricaScoring)
+	if !hasDiscarded(s, canPlayEvent, notScoring) {
+		s.Transcribe("There is no event to play from the discard pile.")
+		return
+	}
+	card := SelectDiscarded(s, player, canPlayEvent, notScoring)
 	s.Discard.Remove(card)
 	s.CancelAbility(ViewDiscard, player)
 	s.Transcribe(fmt.Sprintf("%s picks %s from the discard pile.", player, card))
@@ -1336,7 +1341,7 @@ func PlayOrtegaElectedInNicaragua(s *State, player Aff) {
 	   Nicaragua. */
 	nicaragua := s.Countries[Nicaragua]
 	zeroInf(s, nicaragua, USA)
-	adjToNicaragua := func(c *Country) error {
+	adjToNicaragua := func(c *Country, _ []*Country) error {
 		for _, neighbor := range nicaragua.AdjCountries {
 			if neighbor.Id == c.Id {
 				return nil
@@ -1430,6 +1435,10 @@ func PlayAldrichAmesRemix(s *State, player Aff) {
 	/* The US reveals their hand of cards, face-up, for the remainder of the
 	   turn and the USSR discards a card from the US hand. */
 	s.EnablePlayer(ViewOpponentHand, SOV)
+	if len(s.Hands[USA].Cards) == 0 {
+		s.Transcribe("The US has no cards to discard.")
+		return
+	}
 	card := selectCardFrom(s, SOV, "Choose a card to discard from the US player's hand.", s.Hands[USA].Cards, false)
 	s.Hands[USA].Remove(card)
 	s.Transcribe(fmt.Sprintf("%s discarded from US hand for Aldrich Ames Remix.", card))
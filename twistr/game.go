@@ -1,7 +1,6 @@
 package twistr
 
 import "fmt"
-import "log"
 import "strings"
 
 // Game-running functions.
@@ -21,12 +20,12 @@ func Deal(s *State) {
 	drawIfNeeded := func(player Aff) {
 		if needCard(player) {
 			if len(s.Deck.Cards) == 0 {
-				log.Printf("Out of cards at %s\n", player)
+				debugf("deck", "Out of cards at %s\n", player)
 				ShuffleInDiscard(s)
 			}
 			card := s.Deck.Draw(1)[0]
 			s.Hands[player].Push(card)
-			log.Printf("%s draws %s, deck length %d\n", player, card, len(s.Deck.Cards))
+			debugf("deck", "%s draws %s, deck length %d\n", player, card, len(s.Deck.Cards))
 		}
 	}
 	for needCard(USA) || needCard(SOV) {
@@ -40,21 +39,43 @@ func ShuffleInDiscard(s *State) {
 	ShuffleIn(s, s.Discard.Draw(len(s.Discard.Cards)))
 }
 
+// drawCards draws n cards for an event, shuffling in the discard pile if the
+// draw pile runs out. There may be fewer than n cards left to draw.
+func drawCards(s *State, n int) []Card {
+	drawn := append([]Card{}, s.Deck.Draw(Min(n, len(s.Deck.Cards)))...)
+	if len(drawn) < n && len(s.Discard.Cards) > 0 {
+		ShuffleInDiscard(s)
+		drawn = append(drawn, s.Deck.Draw(Min(n-len(drawn), len(s.Deck.Cards)))...)
+	}
+	return drawn
+}
+
 func Start(s *State) {
-	// Early war cards into the draw deck
-	ShuffleIn(s, EarlyWar)
-	Deal(s)
-	s.Redraw(s.Game)
-	// SOV chooses 6 influence in E europe
-	SelectInfluenceExactly(s, SOV, "6 influence in East Europe",
-		PlusInf(SOV, 1), 6, InRegion(EastEurope))
-	s.Commit()
-	// US chooses 7 influence in W europe
-	SelectInfluenceExactly(s, USA, "7 influence in West Europe",
-		PlusInf(USA, 1), 7, InRegion(WestEurope))
+	if s.RestoreSnapshot() {
+		s.Redraw(s.Game)
+	} else {
+		// Early war cards into the draw deck
+		ShuffleIn(s, EarlyWar)
+		Deal(s)
+		s.Redraw(s.Game)
+		// SOV chooses 6 influence in E europe
+		SelectInfluenceExactly(s, SOV, "6 influence in East Europe",
+			PlusInf(SOV, 1), 6, InRegion(EastEurope))
+		s.Commit()
+		// US chooses 7 influence in W europe
+		SelectInfluenceExactly(s, USA, "7 influence in West Europe",
+			PlusInf(USA, 1), 7, InRegion(WestEurope))
 
-	s.Commit()
-	for s.Turn = 1; s.Turn <= 10; s.Turn++ {
+		s.Commit()
+	}
+	playTurns(s)
+}
+
+// playTurns plays the game on from the start of the current turn. A game
+// played out to the end of turn 10 is left with Turn at 11.
+func playTurns(s *State) {
+	for ; s.Turn <= 10; s.Turn++ {
+		s.TakeSnapshot()
 		switch s.Turn {
 		case 4:
 			s.Transcribe("Shuffling in Mid War.")
@@ -74,11 +95,11 @@ func ThermoNuclearWar(s *State, caused Aff) {
 
 func ShuffleIn(s *State, cards []Card) {
 	s.Deck.Push(cards...)
-	log.Printf("Deck pre shuffle %v\n", s.Deck.Names())
+	debugf("deck", "Deck pre shuffle %v\n", s.Deck.Names())
 	order := SelectShuffle(s, s.Deck)
 	s.Deck.Reorder(order)
-	log.Printf("Deck post shuffle %v\n", s.Deck.Names())
-	log.Printf("Committing shuffle\n")
+	debugf("deck", "Deck post shuffle %v\n", s.Deck.Names())
+	debugf("commit", "Committing shuffle\n")
 	s.Commit()
 }
 
@@ -148,7 +169,8 @@ func Turn(s *State) {
 			s.Redraw(s.Game)
 			Action(s)
 		}
-		if !usaDone {
+		// The USSR's action may have taken the US's last card.
+		if !usaDone && !outOfCards(s, USA) {
 			s.Transcribe(fmt.Sprintf("= %s AR %d.", USA, s.AR))
 			s.Phasing = USA
 			s.Redraw(s.Game)
@@ -226,6 +248,7 @@ func Action(s *State) {
 	case s.Effect(MissileEnvy, s.Phasing.Opp()):
 		card := Cards[MissileEnvy]
 		s.Hands[s.Phasing].Remove(card)
+		s.happen(GameEvent{Player: s.Phasing, Kind: OPS.Ref(), What: card.Ref()})
 		PlayOps(s, s.Phasing, card)
 		s.Cancel(MissileEnvy)
 	default:
@@ -251,6 +274,7 @@ func PlayCard(s *State, player Aff, card Card) (pk PlayKind) {
 	// Safe to remove a card that isn't actually in the hand
 	s.Hands[player].Remove(card)
 	pk = SelectPlay(s, player, card)
+	s.happen(GameEvent{Player: player, Kind: pk.Ref(), What: card.Ref()})
 	switch pk {
 	case SPACE:
 		PlaySpace(s, player, card)
@@ -326,6 +350,12 @@ func ConductOpsFree(s *State, player Aff, card Card, kinds ...OpsKind) {
 }
 
 func conductOps(s *State, player Aff, card Card, free bool, kinds []OpsKind) {
+	if len(kinds) == 0 {
+		kinds = []OpsKind{COUP, REALIGN, INFLUENCE}
+	}
+	if !anyCountry(s, CanCoup(s, player, free)) {
+		kinds = withoutKind(kinds, COUP)
+	}
 	switch SelectOps(s, player, card, kinds...) {
 	case COUP:
 		OpCoup(s, player, card, free)
@@ -337,12 +367,15 @@ func conductOps(s *State, player Aff, card Card, free bool, kinds []OpsKind) {
 }
 
 func OpRealign(s *State, player Aff, card Card, free bool) {
-	selectInfluence(s, player, fmt.Sprintf("Realigns with %s (%d)", card.Name, ComputeCardOps(s, player, card, nil)),
+	selectTargets(s, player, fmt.Sprintf("Realigns with %s (%d)", card.Name, ComputeCardOps(s, player, card, nil)),
 		func(s *State, c *Country) {
 			Realign(s, player, c)
 		},
 		OpsLimit(s, player, card), false,
 		NormalCost,
+		func(c *Country) []string {
+			return ComputeRealignOdds(s, player, c).Lines()
+		},
 		CanRealign(s, player, free))
 }
 
@@ -353,19 +386,38 @@ func OpCoup(s *State, player Aff, card Card, free bool, checks ...countryCheck)
 	} else {
 		msg = fmt.Sprintf("Coup with %s (%d)", card.Name, ComputeCardOps(s, player, card, nil))
 	}
+	checks = append(checks, CanCoup(s, player, free))
+	if !anyCountry(s, checks...) {
+		s.Transcribe(fmt.Sprintf("%s has no country to coup.", player))
+		return
+	}
 	s.Transcribe(fmt.Sprintf("%s will coup.", player))
-	selectInfluence(s, player, msg,
+	selectTargets(s, player, msg,
 		func(s *State, c *Country) {
 			success = Coup(s, player, card, c, free)
 		},
 		LimitN(1), true,
 		NormalCost,
-		append(checks, CanCoup(s, player, free))...)
+		func(c *Country) []string {
+			return ComputeCoupOdds(s, player, card, c, free).Lines()
+		},
+		checks...)
 	return
 }
 
+// withoutKind returns kinds less kind.
+func withoutKind(kinds []OpsKind, kind OpsKind) []OpsKind {
+	left := []OpsKind{}
+	for _, k := range kinds {
+		if k != kind {
+			left = append(left, k)
+		}
+	}
+	return left
+}
+
 func OpInfluence(s *State, player Aff, card Card) {
-	chernobylCheck := func(c *Country) error {
+	chernobylCheck := func(c *Country, _ []*Country) error {
 		if s.Effect(Chernobyl) && player == SOV && c.In(s.ChernobylRegion) {
 			return fmt.Errorf("May not add influence in %s due to Chernobyl!", s.ChernobylRegion.Name)
 		}
@@ -427,6 +479,8 @@ func SelectPlay(s *State, player Aff, card Card) (pk PlayKind) {
 		canEvent = false
 	case card.Prevented(s.Game):
 		canEvent = false
+	case card.Id == UNIntervention && !hasInHand(s, player, func(c Card) bool { return c.Aff == player.Opp() }):
+		canEvent = false
 	}
 	if !CanAdvance(s, player, ComputeCardOps(s, player, card, nil)) {
 		canSpace = false
@@ -438,17 +492,24 @@ func SelectPlay(s *State, player Aff, card Card) (pk PlayKind) {
 	if canSpace {
 		choices = append(choices, SPACE.Ref())
 	}
-	getInput(s, player, &pk, fmt.Sprintf("Playing %s", card.Name), choices...)
+	s.Decide(&Decision{
+		Prompt:  Prompt{player, inputKind(&pk), fmt.Sprintf("Playing %s", card.Name)},
+		Choices: choices,
+		Card:    card,
+		Ops:     ComputeCardOps(s, player, card, nil),
+		thing:   &pk,
+	})
 	return
 }
 
 // Caller can pass in an optional whitelist of acceptable kinds.
 func SelectOps(s *State, player Aff, card Card, kinds ...OpsKind) (o OpsKind) {
+	ops := ComputeCardOps(s, player, card, nil)
 	var message string
 	if card.Id == FreeOps {
-		message = fmt.Sprintf("Playing a %d ops card (%d)", card.Ops, ComputeCardOps(s, player, card, nil))
+		message = fmt.Sprintf("Playing a %d ops card (%d)", card.Ops, ops)
 	} else {
-		message = fmt.Sprintf("Playing %s for ops (%d)", card.Name, ComputeCardOps(s, player, card, nil))
+		message = fmt.Sprintf("Playing %s for ops (%d)", card.Name, ops)
 	}
 	var choices []string
 	if len(kinds) == 0 {
@@ -458,26 +519,47 @@ func SelectOps(s *State, player Aff, card Card, kinds ...OpsKind) (o OpsKind) {
 			choices = append(choices, k.Ref())
 		}
 	}
-	getInput(s, player, &o, message, choices...)
+	s.Decide(&Decision{
+		Prompt:  Prompt{player, inputKind(&o), message},
+		Choices: choices,
+		Card:    card,
+		Ops:     ops,
+		thing:   &o,
+	})
 	return
 }
 
 func SelectShuffle(s *State, d *Deck) (cardOrder []Card) {
-	// Duplicates what getInput does. It doesn't make sense to reuse getInput
-	// because this will never ask for user input.
-	remote := !s.Server
-	if s.ReadInto(&cardOrder, remote) {
-		return
-	}
-	// XXX: input bug: shouldn't have to do this here
-	if s.History.Replaying {
-		s.History.Replaying = false
-	}
-	cardOrder = d.Shuffle()
-	s.Log(&cardOrder)
+	s.Decide(&Decision{
+		Prompt: Prompt{NEU, "shuffle", "Shuffle the deck"},
+		Random: func() { cardOrder = d.Shuffle(s.random()) },
+		thing:  &cardOrder,
+		check:  sameCards(&cardOrder, d.Cards),
+	})
 	return
 }
 
+// sameCards returns an inputCheck that requires the cards read into order to
+// be a reordering of cards.
+func sameCards(order *[]Card, cards []Card) inputCheck {
+	return func() error {
+		if len(*order) != len(cards) {
+			return fmt.Errorf("Expected %d cards, got %d", len(cards), len(*order))
+		}
+		counts := make(map[CardId]int)
+		for _, c := range cards {
+			counts[c.Id] += 1
+		}
+		for _, c := range *order {
+			counts[c.Id] -= 1
+			if counts[c.Id] < 0 {
+				return fmt.Errorf("%s is not in the deck", c)
+			}
+		}
+		return nil
+	}
+}
+
 // Return whether the card is an acceptable choice.
 type cardFilter func(Card) bool
 
@@ -510,6 +592,15 @@ func hasInHand(s *State, player Aff, filters ...cardFilter) bool {
 	return false
 }
 
+func hasDiscarded(s *State, filters ...cardFilter) bool {
+	for _, c := range s.Discard.Cards {
+		if passesFilters(c, filters) {
+			return true
+		}
+	}
+	return false
+}
+
 func SelectDiscarded(s *State, player Aff, filters ...cardFilter) Card {
 	s.EnablePlayer(ViewDiscard, player)
 	return selectCardFrom(s, player, "Choose a card from the discard pile.", s.Discard.Cards, false, filters...)
@@ -525,13 +616,12 @@ func selectCardFrom(s *State, player Aff, msg string, from []Card, includeChina
 	if includeChina && passesFilters(Cards[TheChinaCard], filters) {
 		valid[TheChinaCard] = true
 	}
-	prefix := ""
-retry:
-	getInput(s, player, &card, prefix+msg)
-	if !valid[card.Id] {
-		prefix = "Invalid choice. "
-		goto retry
-	}
+	getCheckedInput(s, player, &card, msg, func() error {
+		if !valid[card.Id] {
+			return fmt.Errorf("%s is not a valid choice", card)
+		}
+		return nil
+	})
 	return
 }
 
@@ -543,15 +633,14 @@ func SelectSomeCards(s *State, player Aff, message string, cards []Card) (select
 		cardSet[c.Id] = true
 	}
 	message = fmt.Sprintf("%s: %s", message, strings.Join(cardnames, ", "))
-	prefix := ""
-retry:
-	getInput(s, player, &selected, prefix+message)
-	for _, c := range selected {
-		if !cardSet[c.Id] {
-			prefix = "Invalid choice. "
-			goto retry
+	getCheckedInput(s, player, &selected, message, func() error {
+		for _, c := range selected {
+			if !cardSet[c.Id] {
+				return fmt.Errorf("%s is not a valid choice", c)
+			}
 		}
-	}
+		return nil
+	})
 	return
 }
 
@@ -560,46 +649,73 @@ func SelectChoice(s *State, player Aff, message string, choices ...string) (choi
 	return
 }
 
-// Get user input. Replay log is tried first. If that's exhausted, we ask the
-// local user or read from the peer. Non-replayed inputs are written to the AOF.
-// Non-replayed local inputs are written to the peer.
-// See State.ReadInto, .Log
+// Get user input. See State.Decide.
 func getInput(s *State, player Aff, thing interface{}, message string, choices ...string) {
-	remote := player != s.LocalPlayer
-	log.Printf("Reading from %s '%s'\n", player, message)
-	if s.ReadInto(thing, remote) {
-		return
+	getCheckedInput(s, player, thing, message, nil, choices...)
+}
+
+// Like getInput, but the input must also pass check, whether it comes from the
+// local user or the peer.
+func getCheckedInput(s *State, player Aff, thing interface{}, message string, check inputCheck, choices ...string) {
+	s.Decide(&Decision{
+		Prompt:  Prompt{player, inputKind(thing), message},
+		Choices: choices,
+		thing:   thing,
+		check:   check,
+	})
+}
+
+// inChoices returns an inputCheck that requires thing, as it would be logged,
+// to be one of choices, and to pass check.
+func inChoices(thing interface{}, choices []string, check inputCheck) inputCheck {
+	return func() error {
+		if len(choices) > 0 {
+			b, err := Marshal(thing)
+			if err != nil {
+				return err
+			}
+			if !validChoice(string(b), choices) {
+				return fmt.Errorf("'%s' is not a valid choice", string(b))
+			}
+		}
+		if check != nil {
+			return check()
+		}
+		return nil
 	}
-	localInput(s, thing, message, choices...)
-	s.Log(thing)
 }
 
 // Like getInput, but for computer-decided things.
-func getRandom(s *State, player Aff, thing interface{}, impl func()) {
-	remote := player != s.LocalPlayer
-	log.Printf("Reading random from %s\n", player)
-	if s.ReadInto(thing, remote) {
-		return
-	}
-	// XXX: input bug: shouldn't have to do this here
-	if s.History.Replaying {
-		s.History.Replaying = false
-	}
-	impl()
-	s.Log(thing)
+func getRandom(s *State, player Aff, thing interface{}, impl func(), check inputCheck) {
+	s.Decide(&Decision{
+		Prompt: Prompt{player, "random", "Random " + inputKind(thing)},
+		Random: impl,
+		thing:  thing,
+		check:  check,
+	})
 }
 
 func SelectRandomCard(s *State, player Aff) (card Card) {
 	getRandom(s, player, &card, func() {
-		n := rng.Intn(len(s.Hands[player].Cards))
+		n := s.random().Intn(len(s.Hands[player].Cards))
 		card = s.Hands[player].Cards[n]
+	}, func() error {
+		if !s.Hands[player].Contains(card) {
+			return fmt.Errorf("%s is not in %s hand", card, player)
+		}
+		return nil
 	})
 	return
 }
 
 func SelectRoll(s *State, player Aff) (roll int) {
 	getRandom(s, player, &roll, func() {
-		roll = Roll()
+		roll = Roll(s.random())
+	}, func() error {
+		if roll < 1 || roll > 6 {
+			return fmt.Errorf("Bad roll %d", roll)
+		}
+		return nil
 	})
 	return
 }
@@ -610,12 +726,14 @@ func SelectFirst(s *State, player Aff) (first Aff) {
 	return
 }
 
-type countryCheck func(*Country) error
+// A countryCheck validates a country chosen in a selection, given those
+// chosen before it.
+type countryCheck func(c *Country, chosen []*Country) error
 
 // InRegion returns a countryCheck that will reject any country that is not in
 // at least one of the given regions.
 func InRegion(regions ...Region) countryCheck {
-	return func(c *Country) error {
+	return func(c *Country, _ []*Country) error {
 		for _, r := range regions {
 			if c.In(r) {
 				return nil
@@ -630,7 +748,7 @@ func InRegion(regions ...Region) countryCheck {
 }
 
 func InCountries(countries ...CountryId) countryCheck {
-	return func(c *Country) error {
+	return func(c *Country, _ []*Country) error {
 		for _, cid := range countries {
 			if cid == c.Id {
 				return nil
@@ -641,7 +759,7 @@ func InCountries(countries ...CountryId) countryCheck {
 }
 
 func ControlledBy(aff Aff) countryCheck {
-	return func(c *Country) error {
+	return func(c *Country, _ []*Country) error {
 		if c.Controlled() != aff {
 			return fmt.Errorf("%s is not %s-controlled", c, aff)
 		}
@@ -650,7 +768,7 @@ func ControlledBy(aff Aff) countryCheck {
 }
 
 func NotControlledBy(aff Aff) countryCheck {
-	return func(c *Country) error {
+	return func(c *Country, _ []*Country) error {
 		if c.Controlled() == aff {
 			return fmt.Errorf("%s is %s-controlled", c, aff)
 		}
@@ -659,7 +777,7 @@ func NotControlledBy(aff Aff) countryCheck {
 }
 
 func NoInfluence(aff Aff) countryCheck {
-	return func(c *Country) error {
+	return func(c *Country, _ []*Country) error {
 		if c.Inf[aff] != 0 {
 			return fmt.Errorf("%s has influence in %s", aff, c.Name)
 		}
@@ -667,11 +785,17 @@ func NoInfluence(aff Aff) countryCheck {
 	}
 }
 
+// MaxPerCountry returns a countryCheck that will reject any country already
+// chosen n times.
 func MaxPerCountry(n int) countryCheck {
-	counts := make(map[CountryId]int)
-	return func(c *Country) error {
-		counts[c.Id] += 1
-		if counts[c.Id] > n {
+	return func(c *Country, chosen []*Country) error {
+		count := 1
+		for _, prev := range chosen {
+			if prev.Id == c.Id {
+				count++
+			}
+		}
+		if count > n {
 			return fmt.Errorf("Too much in %s", c.Name)
 		}
 		return nil
@@ -679,7 +803,7 @@ func MaxPerCountry(n int) countryCheck {
 }
 
 func HasInfluence(aff Aff) countryCheck {
-	return func(c *Country) error {
+	return func(c *Country, _ []*Country) error {
 		if c.Inf[aff] == 0 {
 			return fmt.Errorf("No %s influence in %s", aff, c.Name)
 		}
@@ -694,7 +818,7 @@ func CanReach(s *State, player Aff) countryCheck {
 			influenced[cid] = true
 		}
 	}
-	return func(c *Country) error {
+	return func(c *Country, _ []*Country) error {
 		if influenced[c.Id] {
 			return nil
 		}
@@ -832,26 +956,25 @@ func PseudoCard(ops int) Card {
 
 func AutoWin(s *State, player Aff, why string) {
 	s.Transcribe(fmt.Sprintf("%s wins the game due to %s.", player, why))
+	s.happen(GameEvent{Player: player, Kind: "win", What: why})
 	Finish(s, player)
 }
 
 func Finish(s *State, victor Aff) {
+	if s.previewing != nil || s.headless {
+		panic(previewEnd{over: true, victor: victor})
+	}
+	if s.Viewer != nil {
+		s.Viewer.End(s)
+	}
 	// XXX
 	for {
 		Solicit(s.UI, fmt.Sprintf("Okay %s won", victor), nil)
 	}
 }
 
-func Score(s *State, player Aff, region Region) {
-	sr := ScoreRegion(s.Game, region)
-	if VPAward(sr.Levels[USA], region) == WIN {
-		AutoWin(s, USA, "control of Europe")
-		return
-	}
-	if VPAward(sr.Levels[SOV], region) == WIN {
-		AutoWin(s, SOV, "control of Europe")
-		return
-	}
+// scoreMods returns what each side scores in region, other than a win.
+func scoreMods(sr ScoreResult, region Region) [2][]Mod {
 	mods := [2][]Mod{{}, {}}
 	tally := func(aff Aff) {
 		level := sr.Levels[aff]
@@ -863,6 +986,20 @@ func Score(s *State, player Aff, region Region) {
 	}
 	tally(USA)
 	tally(SOV)
+	return mods
+}
+
+func Score(s *State, player Aff, region Region) {
+	sr := ScoreRegion(s.Game, region)
+	if VPAward(sr.Levels[USA], region) == WIN {
+		AutoWin(s, USA, "control of Europe")
+		return
+	}
+	if VPAward(sr.Levels[SOV], region) == WIN {
+		AutoWin(s, SOV, "control of Europe")
+		return
+	}
+	mods := scoreMods(sr, region)
 	usaScore := TotalMod(mods[USA])
 	sovScore := TotalMod(mods[SOV])
 	s.Transcribe(fmt.Sprintf("US scores %d: %s.", usaScore, ModSummary(mods[USA])))
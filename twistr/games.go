@@ -0,0 +1,238 @@
+package twistr
+
+import "bufio"
+import "encoding/json"
+import "fmt"
+import "io"
+import "os"
+import "path/filepath"
+import "sort"
+import "strconv"
+import "strings"
+import "text/tabwriter"
+import "time"
+
+// A SavedGame is a game kept in DataDir, either hosted by us or copied from
+// the host while we were the guest.
+type SavedGame struct {
+	Name string
+	// Set when we only hold the guest copy of the game.
+	Guest  bool
+	Header *AofHeader
+	// As of the start of the latest turn with a snapshot the AOF vouches
+	// for; 0 if unknown.
+	Turn       int
+	VP         int
+	LastPlayed time.Time
+}
+
+func (sg *SavedGame) AofPath() string {
+	if sg.Guest {
+		return filepath.Join(DataDir, "guest", sg.Name+".aof")
+	}
+	return filepath.Join(DataDir, sg.Name+".aof")
+}
+
+// gameFiles returns every file in dir belonging to the game called name.
+func gameFiles(dir, name string) []string {
+	files := []string{}
+	for _, ext := range []string{".aof", ".aof.v0", ".key", ".snap", ".desync", ".log"} {
+		path := filepath.Join(dir, name+ext)
+		if _, err := os.Stat(path); err == nil {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// allGameFiles returns the game's files, both hosted and guest copies.
+func allGameFiles(name string) []string {
+	return append(gameFiles(DataDir, name), gameFiles(filepath.Join(DataDir, "guest"), name)...)
+}
+
+// ListGames returns the saved games, most recently played first. A guest copy
+// is only listed when we do not host a game of the same name.
+func ListGames() ([]*SavedGame, error) {
+	games := []*SavedGame{}
+	seen := make(map[string]bool)
+	for _, guest := range []bool{false, true} {
+		dir := DataDir
+		if guest {
+			dir = filepath.Join(DataDir, "guest")
+		}
+		paths, err := filepath.Glob(filepath.Join(dir, "*.aof"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			name := strings.TrimSuffix(filepath.Base(path), ".aof")
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			games = append(games, readSavedGame(name, guest))
+		}
+	}
+	sort.Slice(games, func(i, j int) bool {
+		return games[i].LastPlayed.After(games[j].LastPlayed)
+	})
+	return games, nil
+}
+
+// FindGame returns the saved game called name, or an error if there is none.
+func FindGame(name string) (*SavedGame, error) {
+	games, err := ListGames()
+	if err != nil {
+		return nil, err
+	}
+	for _, sg := range games {
+		if sg.Name == name {
+			return sg, nil
+		}
+	}
+	return nil, fmt.Errorf("No saved game '%s'", name)
+}
+
+func readSavedGame(name string, guest bool) *SavedGame {
+	sg := &SavedGame{Name: name, Guest: guest}
+	path := sg.AofPath()
+	if fi, err := os.Stat(path); err == nil {
+		sg.LastPlayed = fi.ModTime()
+	}
+	sg.Header, _ = ReadAofHeader(path)
+	// Only an AOF whose chain holds can vouch for its snapshots.
+	lines, err := loadAof(path)
+	if err != nil {
+		return sg
+	}
+	if snap := lastSnapshot(SnapshotPath(path), sealedSnapshots(lines)); snap != nil {
+		var gj gameJSON
+		if json.Unmarshal(snap.Game, &gj) == nil {
+			sg.Turn, sg.VP = gj.Turn, gj.VP
+		}
+	}
+	return sg
+}
+
+// lastSnapshot returns the newest snapshot in the snapshot file at path that
+// sealed vouches for, or nil if there is none.
+func lastSnapshot(path string, sealed map[string]string) *Snapshot {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer in.Close()
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var last *Snapshot
+	for scanner.Scan() {
+		snap := &Snapshot{}
+		if json.Unmarshal(scanner.Bytes(), snap) != nil {
+			continue
+		}
+		if hash, ok := sealed[snap.Digest]; ok && hash == snap.hash() {
+			last = snap
+		}
+	}
+	return last
+}
+
+// WriteGames writes a table of saved games to w.
+func WriteGames(w io.Writer, games []*SavedGame) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTURN\tVP\tUSA\tUSSR\tHOST\tLAST PLAYED")
+	for _, sg := range games {
+		turn, vp := "?", "?"
+		if sg.Turn > 0 {
+			turn, vp = strconv.Itoa(sg.Turn), strconv.Itoa(sg.VP)
+		}
+		players, host := [2]string{"?", "?"}, "?"
+		if sg.Header != nil {
+			players, host = sg.Header.Players, sg.Header.Host.String()
+		}
+		if sg.Guest {
+			host += " (guest copy)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", sg.Name, turn, vp,
+			players[USA], players[SOV], host, sg.LastPlayed.Format("2006-01-02 15:04"))
+	}
+	return tw.Flush()
+}
+
+// ArchiveGame moves the game's files under DataDir/archive, out of the list
+// of saved games.
+func ArchiveGame(name string) error {
+	files := allGameFiles(name)
+	if len(files) == 0 {
+		return fmt.Errorf("No saved game '%s'", name)
+	}
+	for _, path := range files {
+		rel, err := filepath.Rel(DataDir, path)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(DataDir, "archive", rel)
+		if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.Rename(path, dest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenameGame renames all of the game's files.
+func RenameGame(name, newName string) error {
+	if !ValidName.MatchString(newName) {
+		return fmt.Errorf("Invalid game name '%s'", newName)
+	}
+	if len(allGameFiles(newName)) > 0 {
+		return fmt.Errorf("A game named '%s' already exists", newName)
+	}
+	files := allGameFiles(name)
+	if len(files) == 0 {
+		return fmt.Errorf("No saved game '%s'", name)
+	}
+	for _, path := range files {
+		base := filepath.Base(path)
+		dest := filepath.Join(filepath.Dir(path), newName+strings.TrimPrefix(base, name))
+		if err := os.Rename(path, dest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteGame removes all of the game's files.
+func DeleteGame(name string) error {
+	files := allGameFiles(name)
+	if len(files) == 0 {
+		return fmt.Errorf("No saved game '%s'", name)
+	}
+	for _, path := range files {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportGame writes the game's AOF to w, after verifying it.
+func ExportGame(name string, w io.Writer) error {
+	sg, err := FindGame(name)
+	if err != nil {
+		return err
+	}
+	lines, err := loadAof(sg.AofPath())
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	for _, line := range lines {
+		if line != "" {
+			fmt.Fprintln(bw, line)
+		}
+	}
+	return bw.Flush()
+}
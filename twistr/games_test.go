@@ -0,0 +1,185 @@
+package twistr
+
+import "bytes"
+import "encoding/json"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "strings"
+import "testing"
+
+// tempDataDir points DataDir at a new directory for the length of the test.
+func tempDataDir(t *testing.T) {
+	dir := DataDir
+	t.Cleanup(func() { DataDir = dir })
+	if err := SetDataDir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// saveGame writes a game called name to DataDir, or its guest directory, with
+// inputs and a snapshot file holding snaps.
+func saveGame(t *testing.T, name string, guest bool, inputs []string, snaps ...*Snapshot) string {
+	header := NewAofHeader(USA, [2]string{"alice", "bob"}, RNGLive)
+	return saveGameAs(t, name, guest, header, inputs, snaps...)
+}
+
+// saveGameAs is saveGame with the AOF's header.
+func saveGameAs(t *testing.T, name string, guest bool, header *AofHeader, inputs []string, snaps ...*Snapshot) string {
+	dir := DataDir
+	if guest {
+		dir = filepath.Join(DataDir, "guest")
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name+".aof")
+	if _, err := writeAof(path, header, []byte("secret"), inputs); err != nil {
+		t.Fatal(err)
+	}
+	b := new(bytes.Buffer)
+	for _, snap := range snaps {
+		line, err := json.Marshal(snap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Write(append(line, '\n'))
+	}
+	if err := ioutil.WriteFile(SnapshotPath(path), b.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func testSnapshot(inputs, turn, vp int) *Snapshot {
+	game, _ := json.Marshal(gameJSON{Turn: turn, VP: vp})
+	return &Snapshot{Inputs: inputs, Digest: strings.Repeat("d", inputs), Game: game}
+}
+
+func TestListGamesSealedSnapshots(t *testing.T) {
+	tempDataDir(t)
+	sealed, unsealed := testSnapshot(1, 3, 2), testSnapshot(2, 5, 7)
+	saveGame(t, "g", false, []string{"usa", snapLine(sealed), "coup"}, sealed, unsealed)
+	sg, err := FindGame("g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sg.Turn != 3 || sg.VP != 2 {
+		t.Errorf("Got turn %d VP %d, want the sealed snapshot's turn 3 VP 2", sg.Turn, sg.VP)
+	}
+
+	// A snapshot whose seal is in an AOF that doesn't verify doesn't count.
+	path := saveGame(t, "edited", false, []string{"usa", snapLine(sealed), "coup"}, sealed)
+	b, _ := ioutil.ReadFile(path)
+	if err := ioutil.WriteFile(path, bytes.Replace(b, []byte("coup"), []byte("ops"), 1), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if sg, err = FindGame("edited"); err != nil {
+		t.Fatal(err)
+	}
+	if sg.Turn != 0 {
+		t.Errorf("Got turn %d from an edited AOF, want it unknown", sg.Turn)
+	}
+}
+
+func TestListGamesPrefersHosted(t *testing.T) {
+	tempDataDir(t)
+	saveGame(t, "g", false, []string{"usa"})
+	saveGame(t, "g", true, []string{"usa"})
+	saveGame(t, "h", true, []string{"usa"})
+	games, err := ListGames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	guest := make(map[string]bool)
+	for _, sg := range games {
+		guest[sg.Name] = sg.Guest
+	}
+	if len(games) != 2 || guest["g"] || !guest["h"] {
+		t.Errorf("Got %v, want hosted g and guest h", guest)
+	}
+}
+
+func TestArchiveGame(t *testing.T) {
+	tempDataDir(t)
+	saveGame(t, "g", false, []string{"usa"})
+	saveGame(t, "g", true, []string{"usa"})
+	if err := ArchiveGame("g"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FindGame("g"); err == nil {
+		t.Error("Archived game still listed")
+	}
+	for _, path := range []string{"g.aof", "g.key", "g.snap", "guest/g.aof"} {
+		if _, err := os.Stat(filepath.Join(DataDir, "archive", path)); err != nil {
+			t.Errorf("Not archived: %s", err)
+		}
+	}
+	if err := ArchiveGame("g"); err == nil {
+		t.Error("Archived a game that isn't there")
+	}
+}
+
+func TestRenameGame(t *testing.T) {
+	tempDataDir(t)
+	saveGame(t, "g", false, []string{"usa"})
+	saveGame(t, "taken", false, []string{"usa"})
+	if err := RenameGame("g", "taken"); err == nil {
+		t.Error("Renamed a game over another")
+	}
+	if err := RenameGame("g", "../escape"); err == nil {
+		t.Error("Renamed a game outside the data directory")
+	}
+	if err := RenameGame("g", "h"); err != nil {
+		t.Fatal(err)
+	}
+	if len(allGameFiles("g")) != 0 {
+		t.Errorf("Left behind %v", allGameFiles("g"))
+	}
+	// The key moves with the AOF, so it still verifies.
+	if _, _, err := ValidateAof(filepath.Join(DataDir, "h.aof")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeleteGame(t *testing.T) {
+	tempDataDir(t)
+	saveGame(t, "g", false, []string{"usa"})
+	saveGame(t, "g", true, []string{"usa"})
+	saveGame(t, "h", false, []string{"usa"})
+	if err := DeleteGame("g"); err != nil {
+		t.Fatal(err)
+	}
+	if files := allGameFiles("g"); len(files) != 0 {
+		t.Errorf("Left behind %v", files)
+	}
+	if _, err := FindGame("h"); err != nil {
+		t.Error(err)
+	}
+	if err := DeleteGame("g"); err == nil {
+		t.Error("Deleted a game that isn't there")
+	}
+}
+
+func TestExportGame(t *testing.T) {
+	tempDataDir(t)
+	inputs := []string{"usa", "westgermany", "coup"}
+	saveGame(t, "g", false, inputs)
+	b := new(bytes.Buffer)
+	if err := ExportGame("g", b); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimRight(b.String(), "\n"), "\n")
+	if _, got, _, err := parseAof(lines, []byte("secret")); err != nil || strings.Join(got, " ") != strings.Join(inputs, " ") {
+		t.Errorf("Exported inputs %v, %v; want %v", got, err, inputs)
+	}
+
+	path := filepath.Join(DataDir, "g.aof")
+	orig, _ := ioutil.ReadFile(path)
+	if err := ioutil.WriteFile(path, bytes.Replace(orig, []byte("coup"), []byte("ops"), 1), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ExportGame("g", new(bytes.Buffer)); err == nil {
+		t.Error("Exported an edited AOF")
+	}
+}
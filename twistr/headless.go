@@ -0,0 +1,85 @@
+package twistr
+
+import "fmt"
+import "io/ioutil"
+import "math/rand"
+import "time"
+
+// A Headless game is played by its agents alone: there is no UI, no link and
+// no AOF, and nothing the game would show, log or send goes anywhere.
+type Headless struct {
+	// Who answers each side's decisions. Neutral decisions, like shuffles,
+	// go to the US.
+	Agents [2]Agent
+	// Where the dice and shuffles come from; if nil, each run makes a random
+	// source of its own.
+	Dice *rand.Rand
+	// Inputs to play, as logged, before the agents are asked anything; see
+	// History.Played.
+	Inputs []string
+}
+
+// A HeadlessResult is how a headless game went.
+type HeadlessResult struct {
+	Game       *Game
+	Transcript []string
+	// NEU for a draw.
+	Winner Aff
+	// Why the game was won, as recorded when it was, or "final VP".
+	Reason string
+	// The inputs played, as logged. Played again, they play the same game.
+	Inputs []string
+}
+
+// Run plays the game to its end. If the game cannot be finished, as when an
+// agent panics, it returns an error along with the game as far as it got.
+func (h *Headless) Run() (result HeadlessResult, err error) {
+	for _, aff := range []Aff{USA, SOV} {
+		if h.Agents[aff] == nil {
+			return result, fmt.Errorf("No agent for %s", aff)
+		}
+	}
+	history := NewHistory(NullUI{})
+	history.Reset(h.Inputs)
+	s := NewState(history, NewGame(), true, USA, ioutil.Discard)
+	s.LinkOut = NewCmdOut(ioutil.Discard)
+	// Kept for agents that replay the game, which they do from the latest.
+	s.Snapshots = NewSnapshots(nil)
+	s.dice = h.Dice
+	if s.dice == nil {
+		s.dice = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	s.headless = true
+	s.Agents = h.Agents
+	defer func() {
+		result.Game, result.Transcript = s.Game, s.Transcript
+		result.Inputs = s.History.Played()
+		x := recover()
+		switch x := x.(type) {
+		case nil:
+			// Played out, so the VP decide it.
+			result.Winner, result.Reason = NEU, "final VP"
+			switch {
+			case s.VP > 0:
+				result.Winner = USA
+			case s.VP < 0:
+				result.Winner = SOV
+			}
+		case previewEnd:
+			if !x.over {
+				err = fmt.Errorf("Stopped in turn %d", s.Turn)
+				return
+			}
+			result.Winner = x.victor
+			for _, e := range s.Happened {
+				if e.Kind == "win" {
+					result.Reason = e.What
+				}
+			}
+		default:
+			err = fmt.Errorf("Failed in turn %d: %v", s.Turn, x)
+		}
+	}()
+	Start(s)
+	return
+}
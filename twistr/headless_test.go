@@ -0,0 +1,37 @@
+package twistr
+
+import "math/rand"
+import "testing"
+
+// unasked fails any decision it is asked, for games that should be answered
+// from their inputs alone.
+type unasked struct{}
+
+func (unasked) Decide(s *State, d *Decision) string {
+	panic("asked " + d.Message)
+}
+
+func TestHeadlessReplay(t *testing.T) {
+	for seed := int64(1); seed <= 3; seed++ {
+		h := &Headless{
+			Agents: [2]Agent{HeuristicBot{}, NewRandomBot(seed)},
+			Dice:   rand.New(rand.NewSource(seed)),
+		}
+		played, err := h.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		replay := &Headless{Agents: [2]Agent{unasked{}, unasked{}}, Inputs: played.Inputs}
+		replayed, err := replay.Run()
+		if err != nil {
+			t.Fatalf("Seed %d: %s", seed, err.Error())
+		}
+		if replayed.Game.Dump() != played.Game.Dump() {
+			t.Errorf("Seed %d: dump after replay:\n%s\nwant:\n%s", seed, replayed.Game.Dump(), played.Game.Dump())
+		}
+		if replayed.Winner != played.Winner || replayed.Reason != played.Reason {
+			t.Errorf("Seed %d: replay won by %s on %s, want %s on %s", seed,
+				replayed.Winner, replayed.Reason, played.Winner, played.Reason)
+		}
+	}
+}
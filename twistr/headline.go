@@ -35,6 +35,8 @@ func Headline(s *State) {
 	}
 	s.Hands[USA].Remove(usaHl)
 	s.Hands[SOV].Remove(sovHl)
+	s.happen(GameEvent{Player: USA, Kind: "headline", What: usaHl.Ref()})
+	s.happen(GameEvent{Player: SOV, Kind: "headline", What: sovHl.Ref()})
 	if usaHl.Id == Defectors {
 		s.Transcribe("USSR event canceled by Defectors.")
 		s.Discard.Push(usaHl)
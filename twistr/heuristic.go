@@ -0,0 +1,287 @@
+package twistr
+
+import "strings"
+
+// HeuristicBot plays greedily by Evaluate. Where an answer's effect on the
+// board can be previewed, as with placing influence or picking a coup target,
+// it takes the answer that previews best. Elsewhere it goes by rules of
+// thumb: play your own events, put the opponent's cards into space, play
+// scoring cards while they favour you, coup when a coup beats placing
+// influence, and never trip DEFCON.
+type HeuristicBot struct{}
+
+func (b HeuristicBot) Decide(s *State, d *Decision) string {
+	if d.Random != nil {
+		return d.Auto()
+	}
+	legal := d.Legal(s)
+	switch {
+	case legal == nil:
+		return b.chooseCards(s, d)
+	case len(legal) == 1:
+		return legal[0]
+	case d.effect != nil:
+		return b.choosePreviewed(s, d, legal)
+	}
+	switch d.Kind {
+	case "card":
+		return b.chooseCard(s, d, legal)
+	case "playkind":
+		return b.choosePlay(s, d, legal)
+	case "opskind":
+		return b.chooseOps(s, d, legal)
+	case "region":
+		return b.chooseRegion(s, d, legal)
+	case "aff":
+		// Who plays first: we do.
+		if validChoice(d.Player.Ref(), legal) {
+			return d.Player.Ref()
+		}
+	}
+	return b.chooseOther(s, d, legal)
+}
+
+// choosePreviewed takes the answer that previews best. Answers that cannot be
+// previewed are taken only if none can.
+func (b HeuristicBot) choosePreviewed(s *State, d *Decision, legal []string) string {
+	best, bestValue := legal[0], 0.0
+	found := false
+	for _, answer := range legal {
+		v, ok := s.PreviewValue(d, answer, d.Player)
+		if ok && (!found || v > bestValue) {
+			best, bestValue, found = answer, v, true
+		}
+	}
+	return best
+}
+
+func (b HeuristicBot) chooseCard(s *State, d *Decision, legal []string) string {
+	best, bestValue := legal[0], 0.0
+	for i, ref := range legal {
+		card, err := lookupCard(ref)
+		if err != nil {
+			continue
+		}
+		if v := cardValue(s, d.Player, card); i == 0 || v > bestValue {
+			best, bestValue = ref, v
+		}
+	}
+	return best
+}
+
+// cardValue is how much player wants to play card now, headline included.
+func cardValue(s *State, player Aff, card Card) float64 {
+	ops := float64(ComputeCardOps(s, player, card, nil))
+	headline := s.AR == 0
+	switch {
+	case card.Scoring():
+		if scoringCardsHeld(s, player) >= actionsThisTurn(s, player)-s.AR+1 {
+			// Held scoring cards lose the game at the end of the turn.
+			return 100
+		}
+		return 2 * regionLead(s.Game, card.ScoringRegion(), player)
+	case card.Id == TheChinaCard:
+		// Better kept for when it counts.
+		return ops - 3
+	case card.Aff == player:
+		if !eventSafe(s, player, card) {
+			return -10
+		}
+		return ops + 1
+	case card.Aff == player.Opp() && headline:
+		return -10 - ops
+	case card.Aff == player.Opp():
+		if CanAdvance(s, player, int(ops)) {
+			return ops - 1.5
+		}
+		// Played for ops, the event still happens.
+		if !eventSafe(s, player, card) {
+			return -50
+		}
+		return -ops
+	default:
+		return ops
+	}
+}
+
+func scoringCardsHeld(s *State, player Aff) int {
+	n := 0
+	for _, c := range s.Hands[player].Cards {
+		if c.Scoring() {
+			n++
+		}
+	}
+	return n
+}
+
+// regionLead is what player would gain over the opponent by scoring region
+// now.
+func regionLead(g *Game, region Region, player Aff) float64 {
+	sr := ScoreRegion(g, region)
+	switch {
+	case VPAward(sr.Levels[player], region) == WIN:
+		return evalWin
+	case VPAward(sr.Levels[player.Opp()], region) == WIN:
+		return -evalWin
+	}
+	mods := scoreMods(sr, region)
+	return float64(TotalMod(mods[player]) - TotalMod(mods[player.Opp()]))
+}
+
+// eventSafe returns false if player implementing card's event would lose the
+// game outright, as far as can be previewed.
+func eventSafe(s *State, player Aff, card Card) bool {
+	v, ok := s.previewEffect(func() { PlayEvent(s, player, card) }, player)
+	return !ok || v > -evalWin
+}
+
+func (b HeuristicBot) choosePlay(s *State, d *Decision, legal []string) string {
+	card := d.Card
+	switch {
+	case card.Aff == d.Player && validChoice(EVENT.Ref(), legal) && eventSafe(s, d.Player, card):
+		return EVENT.Ref()
+	case card.Aff == d.Player.Opp() && validChoice(SPACE.Ref(), legal):
+		return SPACE.Ref()
+	}
+	if validChoice(OPS.Ref(), legal) {
+		return OPS.Ref()
+	}
+	return legal[0]
+}
+
+// chooseOps coups when the best coup previews better than placing the
+// influence would, and otherwise places influence. It realigns only when it
+// must.
+func (b HeuristicBot) chooseOps(s *State, d *Decision, legal []string) string {
+	player := d.Player
+	card, ops := d.Card, d.Ops
+	base := Evaluate(s.Game, player)
+	infGain := -1.0
+	if validChoice(INFLUENCE.Ref(), legal) {
+		place := countryDecision(s, CanReach(s, player), PlusInf(player, 1))
+		if _, v, ok := bestCountry(s, place, player); ok {
+			infGain = float64(ops) * (v - base)
+		}
+	}
+	if validChoice(COUP.Ref(), legal) {
+		coup := countryDecision(s, CanCoup(s, player, false), func(s *State, c *Country) {
+			Coup(s, player, card, c, false)
+			// The opponent's event follows, at the DEFCON the coup leaves.
+			if card.Aff == player.Opp() {
+				PlayEvent(s, player, card)
+			}
+		})
+		if _, v, ok := bestCoup(s, coup, player); ok && v-base > infGain {
+			return COUP.Ref()
+		}
+	}
+	for _, kind := range []OpsKind{INFLUENCE, REALIGN} {
+		if validChoice(kind.Ref(), legal) {
+			return kind.Ref()
+		}
+	}
+	return legal[0]
+}
+
+// countryDecision makes a decision on one country, so that its answers can be
+// previewed.
+func countryDecision(s *State, check countryCheck, change countryChange) *Decision {
+	var c *Country
+	return &Decision{
+		Prompt:    Prompt{Kind: "country"},
+		thing:     &c,
+		countries: s.Countries,
+		check: func() error {
+			if c == EndSelectCountry {
+				return nil
+			}
+			return check(c, nil)
+		},
+		effect: func() {
+			if c != EndSelectCountry {
+				change(s, c)
+			}
+		},
+	}
+}
+
+// bestCountry returns the country whose answer to d previews best for player,
+// and its value.
+func bestCountry(s *State, d *Decision, player Aff) (best string, value float64, found bool) {
+	for _, answer := range d.Legal(s) {
+		if answer == EndSelectCountryStr {
+			continue
+		}
+		v, ok := s.PreviewValue(d, answer, player)
+		if ok && (!found || v > value) {
+			best, value, found = answer, v, true
+		}
+	}
+	return
+}
+
+// bestCoup is bestCountry for coups. Coups can make the other country
+// decisions cheaper, so a failed coup is not the end of the world, but one
+// that may lose the game is never worth it.
+func bestCoup(s *State, d *Decision, player Aff) (string, float64, bool) {
+	best, value, found := bestCountry(s, d, player)
+	if !found || value <= -evalWin/6 {
+		return "", 0, false
+	}
+	return best, value, true
+}
+
+// chooseRegion picks the region where the opponent leads most, as the only
+// region decision blocks the opponent there.
+func (b HeuristicBot) chooseRegion(s *State, d *Decision, legal []string) string {
+	best, bestValue := legal[0], 0.0
+	for i, ref := range legal {
+		r, err := lookupRegion(ref)
+		if err != nil {
+			continue
+		}
+		if v := regionLead(s.Game, r, d.Player.Opp()); i == 0 || v > bestValue {
+			best, bestValue = ref, v
+		}
+	}
+	return best
+}
+
+// chooseOther answers the events' questions.
+func (b HeuristicBot) chooseOther(s *State, d *Decision, legal []string) string {
+	vp := s.VP
+	if d.Player == SOV {
+		vp = -vp
+	}
+	switch {
+	case strings.Contains(d.Message, "end the game"):
+		// Wargames: only if we are still ahead after giving 6 VP.
+		if vp > 6 {
+			return "yes"
+		}
+		return "no"
+	case validChoice("participate", legal):
+		return "participate"
+	case strings.HasPrefix(d.Message, "Set DEFCON"):
+		return legal[len(legal)-1]
+	case validChoice("yes", legal):
+		return "yes"
+	}
+	return legal[0]
+}
+
+// chooseCards answers decisions on a list of cards by picking those with the
+// opponent's events.
+func (b HeuristicBot) chooseCards(s *State, d *Decision) string {
+	answer := "[ "
+	for _, ref := range d.Members(s) {
+		if card, err := lookupCard(ref); err == nil && card.Aff == d.Player.Opp() {
+			answer += ref + " "
+		}
+	}
+	answer += "]"
+	if d.Check(answer) != nil {
+		return "[ ]"
+	}
+	return answer
+}
@@ -0,0 +1,201 @@
+package twistr
+
+import "fmt"
+import "sort"
+import "strings"
+
+// A Hint is a suggested answer to a decision, with what it would do.
+type Hint struct {
+	Answer string
+	// How the answer ranks, for the player deciding. Only hints of the same
+	// decision compare.
+	Value float64
+	Notes []string
+}
+
+func (h Hint) String() string {
+	if len(h.Notes) == 0 {
+		return h.Answer
+	}
+	return fmt.Sprintf("%s: %s", h.Answer, strings.Join(h.Notes, ", "))
+}
+
+// Hints ranks the legal answers to d, best first, for the player deciding.
+// Answers whose effect can be previewed are ranked by Evaluate, with the VP,
+// DEFCON and military operations they lead to; cards by how much they are
+// worth playing now; and the rest by what HeuristicBot would answer. It
+// returns nil if the answers cannot be listed. The game is left as it was,
+// and nothing is logged.
+func Hints(s *State, d *Decision) []Hint {
+	if d.Random != nil {
+		return nil
+	}
+	legal := d.Legal(s)
+	if legal == nil {
+		return nil
+	}
+	hints := []Hint{}
+	switch {
+	case d.effect != nil:
+		base := Evaluate(s.Game, d.Player)
+		for _, answer := range legal {
+			hints = append(hints, previewHint(s, d, answer, base))
+		}
+	case d.Kind == "card":
+		for _, answer := range legal {
+			hints = append(hints, cardHint(s, d.Player, answer))
+		}
+	default:
+		pick := HeuristicBot{}.Decide(s, d)
+		for _, answer := range legal {
+			h := Hint{Answer: answer}
+			if answer == pick {
+				h.Value = 1
+				h.Notes = []string{"as a bot would play it"}
+			}
+			hints = append(hints, h)
+		}
+	}
+	sort.SliceStable(hints, func(i, j int) bool {
+		return hints[i].Value > hints[j].Value
+	})
+	return hints
+}
+
+// previewHint previews answer, and notes what it does on average over the
+// rolls of the die.
+func previewHint(s *State, d *Decision, answer string, base float64) Hint {
+	h := Hint{Answer: answer, Value: -evalWin - 1}
+	if d.Check(answer) != nil {
+		return h
+	}
+	player := d.Player
+	previews, ok := s.previewRolls(d.effect, player)
+	if !ok {
+		h.Notes = []string{"cannot be previewed"}
+		return h
+	}
+	n := float64(len(previews))
+	value, vp, milOps := 0.0, 0.0, 0.0
+	lowDefcon, highDefcon := s.Defcon, 0
+	losses, wins := 0, 0
+	for _, p := range previews {
+		value += p.value
+		switch {
+		case p.over && p.victor == player:
+			wins++
+			continue
+		case p.over && p.victor == player.Opp():
+			losses++
+			continue
+		}
+		vp += float64(p.vp - s.VP)
+		milOps += float64(p.milOps[player] - s.MilOps[player])
+		lowDefcon, highDefcon = Min(lowDefcon, p.defcon), Max(highDefcon, p.defcon)
+	}
+	h.Value = value / n
+	if player == SOV {
+		vp = -vp
+	}
+	h.Notes = append(h.Notes, fmt.Sprintf("worth %+.1f", h.Value-base))
+	if vp != 0 {
+		h.Notes = append(h.Notes, fmt.Sprintf("VP %+.1f", vp/n))
+	}
+	if milOps != 0 {
+		h.Notes = append(h.Notes, fmt.Sprintf("milops %+.1f", milOps/n))
+	}
+	switch {
+	case highDefcon < s.Defcon:
+		h.Notes = append(h.Notes, fmt.Sprintf("DEFCON falls to %d", lowDefcon))
+	case lowDefcon < s.Defcon:
+		h.Notes = append(h.Notes, fmt.Sprintf("DEFCON may fall to %d", lowDefcon))
+	}
+	if losses > 0 {
+		h.Notes = append(h.Notes, fmt.Sprintf("loses the game %s", chances(losses, len(previews))))
+	}
+	if wins > 0 {
+		h.Notes = append(h.Notes, fmt.Sprintf("wins the game %s", chances(wins, len(previews))))
+	}
+	return h
+}
+
+// chances describes how many of the rolls something happens on.
+func chances(n, rolls int) string {
+	if rolls == 1 {
+		return "outright"
+	}
+	return fmt.Sprintf("on %d in %d rolls", n, rolls)
+}
+
+// cardHint notes what playing card now would mean, by cardValue.
+func cardHint(s *State, player Aff, answer string) Hint {
+	h := Hint{Answer: answer}
+	card, err := lookupCard(answer)
+	if err != nil {
+		return h
+	}
+	h.Value = cardValue(s, player, card)
+	switch {
+	case card.Scoring():
+		lead := regionLead(s.Game, card.ScoringRegion(), player)
+		switch {
+		case lead >= evalWin:
+			h.Notes = append(h.Notes, "wins the game")
+		case lead <= -evalWin:
+			h.Notes = append(h.Notes, "loses the game")
+		default:
+			h.Notes = append(h.Notes, fmt.Sprintf("scores %+.0f VP for you now", lead))
+		}
+	case card.Id == TheChinaCard:
+		h.Notes = append(h.Notes, "better kept for when it counts")
+	case card.Aff == player.Opp():
+		h.Notes = append(h.Notes, fmt.Sprintf("%d ops, with your opponent's event", ComputeCardOps(s, player, card, nil)))
+		if !eventSafe(s, player, card) {
+			h.Notes = append(h.Notes, "the event may lose the game")
+		}
+	default:
+		h.Notes = append(h.Notes, fmt.Sprintf("%d ops", ComputeCardOps(s, player, card, nil)))
+		if card.Aff == player && !eventSafe(s, player, card) {
+			h.Notes = append(h.Notes, "the event may lose the game")
+		}
+	}
+	return h
+}
+
+// hintWarnings notes the dangers player faces whatever the answer: scoring
+// cards still to play this turn, military operations owed and a low DEFCON.
+func hintWarnings(s *State, player Aff) []string {
+	warnings := []string{}
+	if held := scoringCardsHeld(s, player); held > 0 {
+		left := Max(actionsThisTurn(s, player)-s.AR+1, 0)
+		warnings = append(warnings, fmt.Sprintf("You hold %d scoring card(s), with %d action round(s) left to play them.", held, left))
+	}
+	if owed := s.Defcon - s.MilOps[player]; owed > 0 {
+		warnings = append(warnings, fmt.Sprintf("You are %d military operations short; each costs a VP at the end of the turn.", owed))
+	}
+	if s.Defcon == 2 {
+		warnings = append(warnings, "DEFCON is 2: a coup in a battleground, or lowering DEFCON, ends the game.")
+	}
+	return warnings
+}
+
+// ShowHints shows the best n hints for the decision being asked, and the
+// dangers of the position.
+func ShowHints(s *State, n int) {
+	d := s.Asking
+	var hints []Hint
+	if d != nil {
+		hints = Hints(s, d)
+	}
+	if hints == nil {
+		s.UI.Message("No hints for this decision.")
+		return
+	}
+	lines := []string{fmt.Sprintf("Hints for '%s':", d.Message)}
+	for i, h := range hints[:Min(n, len(hints))] {
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, h))
+	}
+	lines = append(lines, hintWarnings(s, d.Player)...)
+	s.Enter(NewLogMode(lines))
+	s.Redraw(s.Game)
+}
@@ -0,0 +1,506 @@
+package twistr
+
+import "bufio"
+import "fmt"
+import "io"
+import "os"
+import "path/filepath"
+import "regexp"
+import "strconv"
+import "strings"
+
+// A Fact is something an external game log says happened: an answer to one
+// of the engine's prompts, the cards a player was dealt, or the VP or DEFCON
+// the engine should agree with.
+type Fact struct {
+	// Where in the external log it comes from.
+	Line int
+	Text string
+	// Turn and AR, or -1 if the log has not said yet.
+	Turn int
+	AR   int
+	// Who answers, or NEU if anyone may.
+	Player Aff
+	// A prompt kind (see Prompt), or "dealt", "vp" or "defcon".
+	Kind  string
+	Input string
+}
+
+func (f *Fact) answers(p Prompt) bool {
+	return f.Kind == p.Kind && (f.Player == p.Player || f.Player == NEU || p.Player == NEU)
+}
+
+// before reports whether the fact belongs to an earlier AR than the game is in.
+func (f *Fact) before(s *State) bool {
+	return f.Turn >= 0 && (f.Turn < s.Turn || f.Turn == s.Turn && f.AR >= 0 && f.AR < s.AR)
+}
+
+// An ImportError is where the engine disagrees with an external game log.
+type ImportError struct {
+	// The fact the engine disagrees with, if any.
+	Fact *Fact
+	// Number of inputs the engine had accepted.
+	Input  int
+	Reason string
+}
+
+func (e *ImportError) Error() string {
+	if e.Fact == nil {
+		return fmt.Sprintf("After input %d: %s", e.Input, e.Reason)
+	}
+	return fmt.Sprintf("Line %d '%s', after input %d: %s", e.Fact.Line, e.Fact.Text, e.Input, e.Reason)
+}
+
+// Names the external logs use that twistr does not.
+var importAliases = map[string]string{
+	"westgermany":            "wgermany",
+	"eastgermany":            "egermany",
+	"northkorea":             "nkorea",
+	"southkorea":             "skorea",
+	"southeastafricanstates": "seafricanstates",
+	"unitedkingdom":          "uk",
+	"dominicanrepublic":      "dominicanrep",
+	"china":                  "thechinacard",
+}
+
+var nonAlnum = regexp.MustCompile("[^a-z0-9]+")
+
+func importName(name string) string {
+	key := nonAlnum.ReplaceAllString(strings.ToLower(name), "")
+	if alias, ok := importAliases[key]; ok {
+		return alias
+	}
+	return key
+}
+
+func importCard(name string) (Card, error) {
+	return lookupCard(importName(name))
+}
+
+func importCountry(name string) (string, error) {
+	key := importName(name)
+	if _, ok := countryIdLookup[key]; ok {
+		return key, nil
+	}
+	if cid, ok := countryShortLookup[strings.ToUpper(strings.TrimSpace(name))]; ok {
+		for key, id := range countryIdLookup {
+			if id == cid {
+				return key, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("Unknown country '%s'", name)
+}
+
+func importSide(name string) (Aff, error) {
+	return lookupAff(strings.ToLower(name))
+}
+
+// translator turns the lines of an external log into facts, remembering where
+// in the game the log is.
+type translator struct {
+	turn int
+	ar   int
+	// Set once a card is played for ops, until the ops are used.
+	pendingOps bool
+	line       int
+	text       string
+}
+
+func (t *translator) fact(player Aff, kind, input string) Fact {
+	return Fact{Line: t.line, Text: t.text, Turn: t.turn, AR: t.ar, Player: player, Kind: kind, Input: input}
+}
+
+// opsKind is the fact choosing what to do with ops, if a card was just played
+// for them.
+func (t *translator) opsKind(player Aff, kind OpsKind) []Fact {
+	if !t.pendingOps {
+		return nil
+	}
+	t.pendingOps = false
+	return []Fact{t.fact(player, "opskind", kind.Ref())}
+}
+
+type importRule struct {
+	re    *regexp.Regexp
+	facts func(t *translator, m []string) ([]Fact, error)
+}
+
+const sidePattern = `(us|usa|ussr)`
+
+func rule(pattern string, facts func(t *translator, m []string) ([]Fact, error)) importRule {
+	return importRule{regexp.MustCompile("^(?i)" + pattern + "$"), facts}
+}
+
+// Each external log line is matched against these in order. Lines matching
+// none are ignored.
+var importRules = []importRule{
+	rule(`turn (\d+),? *headline.*`, func(t *translator, m []string) ([]Fact, error) {
+		t.turn, _ = strconv.Atoi(m[1])
+		t.ar = 0
+		return nil, nil
+	}),
+	rule(`turn (\d+),? *(?:`+sidePattern+` )?ar (\d+).*`, func(t *translator, m []string) ([]Fact, error) {
+		t.turn, _ = strconv.Atoi(m[1])
+		t.ar, _ = strconv.Atoi(m[3])
+		return nil, nil
+	}),
+	rule(`turn (\d+)\.?`, func(t *translator, m []string) ([]Fact, error) {
+		t.turn, _ = strconv.Atoi(m[1])
+		t.ar = -1
+		return nil, nil
+	}),
+	rule(sidePattern+` is dealt:? (.+)`, func(t *translator, m []string) ([]Fact, error) {
+		player, err := importSide(m[1])
+		if err != nil {
+			return nil, err
+		}
+		facts := []Fact{}
+		for _, name := range strings.Split(m[2], ",") {
+			card, err := importCard(name)
+			if err != nil {
+				return nil, err
+			}
+			facts = append(facts, t.fact(player, "dealt", card.Ref()))
+		}
+		return facts, nil
+	}),
+	rule(sidePattern+` headlines (.+?)\.?`, func(t *translator, m []string) ([]Fact, error) {
+		player, err := importSide(m[1])
+		if err != nil {
+			return nil, err
+		}
+		card, err := importCard(m[2])
+		if err != nil {
+			return nil, err
+		}
+		return []Fact{t.fact(player, "card", card.Ref())}, nil
+	}),
+	rule(sidePattern+` plays (.+?) (for operations|for ops|as an event|as event|for the space race|for space)(?:, rolls (\d))?\.?`, func(t *translator, m []string) ([]Fact, error) {
+		player, err := importSide(m[1])
+		if err != nil {
+			return nil, err
+		}
+		card, err := importCard(m[2])
+		if err != nil {
+			return nil, err
+		}
+		facts := []Fact{t.fact(player, "card", card.Ref())}
+		var pk PlayKind
+		switch {
+		case strings.Contains(m[3], "space"):
+			pk = SPACE
+		case strings.Contains(m[3], "event"):
+			pk = EVENT
+		default:
+			pk = OPS
+			t.pendingOps = true
+		}
+		// Scoring cards are played as events without asking.
+		if !card.Scoring() {
+			facts = append(facts, t.fact(player, "playkind", pk.Ref()))
+		}
+		if m[4] != "" {
+			facts = append(facts, t.fact(player, "random", m[4]))
+		}
+		return facts, nil
+	}),
+	rule(sidePattern+` will conduct operations first\.?`, func(t *translator, m []string) ([]Fact, error) {
+		player, err := importSide(m[1])
+		if err != nil {
+			return nil, err
+		}
+		return []Fact{t.fact(player, "aff", player.Ref())}, nil
+	}),
+	rule(sidePattern+` will implement the event first\.?`, func(t *translator, m []string) ([]Fact, error) {
+		player, err := importSide(m[1])
+		if err != nil {
+			return nil, err
+		}
+		return []Fact{t.fact(player.Opp(), "aff", player.Ref())}, nil
+	}),
+	rule(sidePattern+` coups (.+?),? rolls? (\d)\.?`, func(t *translator, m []string) ([]Fact, error) {
+		player, err := importSide(m[1])
+		if err != nil {
+			return nil, err
+		}
+		country, err := importCountry(m[2])
+		if err != nil {
+			return nil, err
+		}
+		return append(t.opsKind(player, COUP),
+			t.fact(player, "country", country),
+			t.fact(player, "random", m[3])), nil
+	}),
+	rule(sidePattern+` realigns (.+?),? rolls? (?:us|usa) (\d),? ussr (\d)\.?`, func(t *translator, m []string) ([]Fact, error) {
+		player, err := importSide(m[1])
+		if err != nil {
+			return nil, err
+		}
+		country, err := importCountry(m[2])
+		if err != nil {
+			return nil, err
+		}
+		return append(t.opsKind(player, REALIGN),
+			t.fact(player, "country", country),
+			t.fact(player, "random", m[3]),
+			t.fact(player, "random", m[4])), nil
+	}),
+	rule(sidePattern+` (?:places|adds) influence (?:in|to):? (.+?)\.?`, func(t *translator, m []string) ([]Fact, error) {
+		player, err := importSide(m[1])
+		if err != nil {
+			return nil, err
+		}
+		facts := t.opsKind(player, INFLUENCE)
+		for _, name := range strings.Split(m[2], ",") {
+			country, err := importCountry(name)
+			if err != nil {
+				return nil, err
+			}
+			facts = append(facts, t.fact(player, "country", country))
+		}
+		return facts, nil
+	}),
+	rule(sidePattern+` rolls (\d)\.?`, func(t *translator, m []string) ([]Fact, error) {
+		player, err := importSide(m[1])
+		if err != nil {
+			return nil, err
+		}
+		return []Fact{t.fact(player, "random", m[2])}, nil
+	}),
+	rule(sidePattern+` chooses (.+?)\.?`, func(t *translator, m []string) ([]Fact, error) {
+		player, err := importSide(m[1])
+		if err != nil {
+			return nil, err
+		}
+		return []Fact{t.fact(player, "choice", importName(m[2]))}, nil
+	}),
+	// Anything else, answered as twistr would be.
+	rule(sidePattern+` answers (\w+): (.+)`, func(t *translator, m []string) ([]Fact, error) {
+		player, err := importSide(m[1])
+		if err != nil {
+			return nil, err
+		}
+		return []Fact{t.fact(player, strings.ToLower(m[2]), strings.ToLower(strings.TrimSpace(m[3])))}, nil
+	}),
+	rule(`vp:? (-?\d+)\.?`, func(t *translator, m []string) ([]Fact, error) {
+		return []Fact{t.fact(NEU, "vp", m[1])}, nil
+	}),
+	rule(`defcon:? (\d)\.?`, func(t *translator, m []string) ([]Fact, error) {
+		return []Fact{t.fact(NEU, "defcon", m[1])}, nil
+	}),
+}
+
+// A leading timestamp or move number, e.g. "[12:01]" or "17.", is dropped.
+var importPrefix = regexp.MustCompile(`^(\[[^\]]*\]|\d+\.)\s*`)
+
+// ParseGameLog reads an external game log, in twistr's import format (see
+// importRules and testdata/import), into facts, returning the numbers of the
+// lines it did not understand alongside them.
+func ParseGameLog(r io.Reader) (facts []Fact, ignored []int, err error) {
+	t := &translator{turn: -1, ar: -1}
+	scanner := bufio.NewScanner(r)
+	for t.line = 1; scanner.Scan(); t.line++ {
+		t.text = strings.TrimSpace(scanner.Text())
+		line := importPrefix.ReplaceAllString(t.text, "")
+		if line == "" {
+			continue
+		}
+		matched := false
+		for _, rule := range importRules {
+			m := rule.re.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			more, err := rule.facts(t, m)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Line %d: %s", t.line, err.Error())
+			}
+			facts = append(facts, more...)
+			matched = true
+			break
+		}
+		if !matched {
+			ignored = append(ignored, t.line)
+		}
+	}
+	return facts, ignored, scanner.Err()
+}
+
+// importer answers the engine's prompts from the facts of an external log.
+type importer struct {
+	facts []Fact
+	used  []bool
+	// The inputs handed to the engine, and the fact behind each.
+	inputs []string
+	from   []*Fact
+}
+
+func (im *importer) disagree(f *Fact, format string, args ...interface{}) {
+	panic(&ImportError{Fact: f, Input: len(im.inputs), Reason: fmt.Sprintf(format, args...)})
+}
+
+func (im *importer) give(s *State, f *Fact, input string) {
+	im.inputs = append(im.inputs, input)
+	im.from = append(im.from, f)
+	s.History.Extend(input)
+}
+
+// check compares the VP and DEFCON the log gives before its next move with
+// the game's.
+func (im *importer) check(s *State) {
+	for i := range im.facts {
+		f := &im.facts[i]
+		if im.used[i] || f.Kind == "dealt" {
+			continue
+		}
+		var have int
+		switch f.Kind {
+		case "vp":
+			have = s.VP
+		case "defcon":
+			have = s.Defcon
+		default:
+			return
+		}
+		im.used[i] = true
+		if want, _ := strconv.Atoi(f.Input); want != have {
+			im.disagree(f, "The log has %s %d, the engine %d", strings.ToUpper(f.Kind), want, have)
+		}
+	}
+}
+
+func (im *importer) Pause(s *State) {
+	im.check(s)
+	if s.Asking.Kind == "shuffle" {
+		im.shuffle(s)
+		return
+	}
+	var first *Fact
+	for i := range im.facts {
+		f := &im.facts[i]
+		if im.used[i] || f.Kind == "dealt" {
+			continue
+		}
+		if first == nil {
+			first = f
+		}
+		if f.Turn > s.Turn || f.Turn == s.Turn && f.AR > s.AR && s.AR >= 0 {
+			break
+		}
+		if f.answers(s.Asking.Prompt) {
+			if first.before(s) {
+				im.disagree(first, "The engine is at turn %d AR %d and never asked for this", s.Turn, s.AR)
+			}
+			im.used[i] = true
+			im.give(s, f, f.Input)
+			return
+		}
+	}
+	switch {
+	case first == nil:
+		panic(endOfRecord{})
+	case s.Asking.Kind == "country":
+		// The log has no more countries, so the player stopped short.
+		im.give(s, nil, EndSelectCountryStr)
+	default:
+		im.disagree(first, "The engine asks %s for a %s (%s) at turn %d AR %d",
+			s.Asking.Player, s.Asking.Kind, s.Asking.Message, s.Turn, s.AR)
+	}
+}
+
+// shuffle orders the deck so the cards the log says were dealt are drawn in
+// turn, as Deal draws them: one each, US first, while both need cards. The
+// rest of the deck stays in order.
+func (im *importer) shuffle(s *State) {
+	dealt := [2][]int{}
+	turn := -2
+	order := []Card{}
+	inDeck := make(map[CardId]bool)
+	for _, c := range s.Deck.Cards {
+		inDeck[c.Id] = true
+	}
+	take := func() bool {
+		for len(dealt[USA]) > 0 || len(dealt[SOV]) > 0 {
+			for _, player := range []Aff{USA, SOV} {
+				if len(dealt[player]) == 0 {
+					continue
+				}
+				i := dealt[player][0]
+				card, _ := lookupCard(im.facts[i].Input)
+				if !inDeck[card.Id] {
+					// Dealt after the next shuffle.
+					return false
+				}
+				dealt[player] = dealt[player][1:]
+				im.used[i] = true
+				inDeck[card.Id] = false
+				order = append(order, card)
+			}
+		}
+		return true
+	}
+	for i := range im.facts {
+		f := &im.facts[i]
+		if im.used[i] || f.Kind != "dealt" {
+			continue
+		}
+		if f.Turn != turn && !take() {
+			break
+		}
+		turn = f.Turn
+		dealt[f.Player] = append(dealt[f.Player], i)
+	}
+	take()
+	for _, c := range s.Deck.Cards {
+		if inDeck[c.Id] {
+			order = append(order, c)
+		}
+	}
+	b, err := Marshal(&order)
+	if err != nil {
+		im.disagree(nil, "%s", err.Error())
+	}
+	im.give(s, nil, string(b))
+}
+
+func (im *importer) End(s *State) {
+	im.check(s)
+	for i := range im.facts {
+		if !im.used[i] && im.facts[i].Kind != "dealt" {
+			im.disagree(&im.facts[i], "The game is over")
+		}
+	}
+	panic(endOfRecord{})
+}
+
+// ImportGame replays the facts of an external log through the engine and
+// returns the inputs it took. If the engine disagrees with the log, the
+// inputs up to that point are returned with an *ImportError saying where.
+func ImportGame(facts []Fact) (inputs []string, err error) {
+	im := &importer{facts: facts, used: make([]bool, len(facts))}
+	_, err = replayHeadless(im)
+	if ih, ok := err.(*InvalidHistory); ok {
+		// The engine won't take the input the log gave.
+		err = &ImportError{Fact: im.from[ih.Line-1], Input: ih.Line - 1, Reason: ih.Err.Error()}
+		return im.inputs[:ih.Line-1], err
+	}
+	return im.inputs, err
+}
+
+// SaveImportedGame saves imported inputs as a new game called name.
+func SaveImportedGame(name string, header *AofHeader, inputs []string) error {
+	if !ValidName.MatchString(name) {
+		return fmt.Errorf("Invalid game name '%s'", name)
+	}
+	path := filepath.Join(DataDir, name+".aof")
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("Game %s already exists", name)
+	}
+	key, err := newAofKey()
+	if err != nil {
+		return err
+	}
+	_, err = writeAof(path, header, key, inputs)
+	return err
+}
@@ -0,0 +1,57 @@
+package twistr
+
+import "os"
+import "path/filepath"
+import "testing"
+
+func importFixture(t *testing.T, name string) ([]string, error) {
+	f, err := os.Open(filepath.Join("testdata", "import", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	facts, ignored, err := ParseGameLog(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ignored) > 0 {
+		t.Errorf("%s: lines not understood: %v", name, ignored)
+	}
+	return ImportGame(facts)
+}
+
+func TestImportAgrees(t *testing.T) {
+	inputs, err := importFixture(t, "turn1.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The shuffle, 13 setup influence, and 51 answers in turn 1.
+	if len(inputs) != 65 {
+		t.Errorf("Got %d inputs, want 65", len(inputs))
+	}
+	moves, err := Notate(inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := moves[len(moves)-1]; last.Turn != 1 || last.AR != 6 || last.Input != "europescoring" {
+		t.Errorf("Last move is '%s'", last)
+	}
+}
+
+func TestImportDisagrees(t *testing.T) {
+	_, err := importFixture(t, "turn1-disagrees.log")
+	ie, ok := err.(*ImportError)
+	if !ok {
+		t.Fatalf("Got %v, want an ImportError", err)
+	}
+	if ie.Fact == nil || ie.Fact.Line != 21 {
+		t.Errorf("Disagrees at %v, want line 21", ie)
+	}
+}
+
+func TestSaveImportedGameName(t *testing.T) {
+	header := NewAofHeader(USA, [2]string{"a", "b"}, RNGImported)
+	if err := SaveImportedGame("../escape", header, nil); err == nil {
+		t.Error("Saved a game under a name outside the data directory")
+	}
+}
@@ -1,6 +1,7 @@
 package twistr
 
 var (
+	// The countries as they start, to look up by name. Each game has its own.
 	Countries      map[CountryId]*Country
 	CentralAmerica Region = Region{
 		Name: "CentralAmerica",
@@ -71,13 +72,14 @@ var (
 )
 
 func init() {
-	resetCountries()
+	Countries = newCountries()
 }
 
-func resetCountries() {
-	Countries = make(map[CountryId]*Country)
+// newCountries makes the countries for a new game.
+func newCountries() map[CountryId]*Country {
+	countries := make(map[CountryId]*Country)
 	for _, c := range countryTable {
-		Countries[c.Id] = &Country{
+		countries[c.Id] = &Country{
 			Id:           c.Id,
 			Name:         c.Name,
 			Inf:          Influence{c.USAInf, c.SOVInf},
@@ -88,11 +90,12 @@ func resetCountries() {
 		}
 	}
 	for _, link := range countryLinks {
-		foo := Countries[link[0]]
-		bar := Countries[link[1]]
+		foo := countries[link[0]]
+		bar := countries[link[1]]
 		foo.AdjCountries = append(foo.AdjCountries, bar)
 		bar.AdjCountries = append(bar.AdjCountries, foo)
 	}
+	return countries
 }
 
 var countryTable = []struct {
@@ -0,0 +1,254 @@
+package twistr
+
+import "encoding/json"
+import "fmt"
+import "sort"
+
+// gameJSON is the JSON form of a Game. Countries, cards and the rest are
+// written by their refs, keyed by side where they belong to one, so that the
+// JSON reads and diffs like the game does. Snapshots keep the game in this
+// form.
+type gameJSON struct {
+	Turn          int                       `json:"turn"`
+	AR            int                       `json:"ar"`
+	Phasing       string                    `json:"phasing"`
+	VP            int                       `json:"vp"`
+	Defcon        int                       `json:"defcon"`
+	MilOps        map[string]int            `json:"milops"`
+	SpaceRace     map[string]int            `json:"spacerace"`
+	SpaceAttempts map[string]int            `json:"spaceattempts"`
+	China         chinaJSON                 `json:"china"`
+	Chernobyl     string                    `json:"chernobyl,omitempty"`
+	Events        map[string]string         `json:"events"`
+	TurnEvents    map[string]string         `json:"turnevents"`
+	SREvents      map[string]string         `json:"srevents"`
+	TurnAbilities map[string][]string       `json:"turnabilities"`
+	Deck          []string                  `json:"deck"`
+	Discard       []string                  `json:"discard"`
+	Removed       []string                  `json:"removed"`
+	Hands         map[string][]string       `json:"hands"`
+	Countries     map[string]map[string]int `json:"countries"`
+	Transcript    []string                  `json:"transcript"`
+	Happened      []happenedJSON            `json:"happened"`
+}
+
+type happenedJSON struct {
+	Turn    int    `json:"turn"`
+	AR      int    `json:"ar"`
+	Player  string `json:"player"`
+	Kind    string `json:"kind"`
+	What    string `json:"what"`
+	Success bool   `json:"success,omitempty"`
+}
+
+type chinaJSON struct {
+	Player string `json:"player"`
+	FaceUp bool   `json:"faceup"`
+}
+
+func bySide(v [2]int) map[string]int {
+	return map[string]int{USA.Ref(): v[USA], SOV.Ref(): v[SOV]}
+}
+
+func cardRefs(d *Deck) []string {
+	refs := make([]string, len(d.Cards))
+	for i, c := range d.Cards {
+		refs[i] = c.Ref()
+	}
+	return refs
+}
+
+func eventRefs(events map[CardId]Aff) map[string]string {
+	refs := make(map[string]string)
+	for id, aff := range events {
+		refs[Cards[id].Ref()] = aff.Ref()
+	}
+	return refs
+}
+
+func (g *Game) MarshalJSON() ([]byte, error) {
+	gj := gameJSON{
+		Turn:          g.Turn,
+		AR:            g.AR,
+		Phasing:       g.Phasing.Ref(),
+		VP:            g.VP,
+		Defcon:        g.Defcon,
+		MilOps:        bySide(g.MilOps),
+		SpaceRace:     bySide(g.SpaceRace),
+		SpaceAttempts: bySide(g.SpaceAttempts),
+		China:         chinaJSON{g.ChinaCardPlayer.Ref(), g.ChinaCardFaceUp},
+		Chernobyl:     g.ChernobylRegion.Ref(),
+		Events:        eventRefs(g.Events),
+		TurnEvents:    eventRefs(g.TurnEvents),
+		SREvents:      make(map[string]string),
+		TurnAbilities: make(map[string][]string),
+		Deck:          cardRefs(g.Deck),
+		Discard:       cardRefs(g.Discard),
+		Removed:       cardRefs(g.Removed),
+		Hands: map[string][]string{
+			USA.Ref(): cardRefs(g.Hands[USA]),
+			SOV.Ref(): cardRefs(g.Hands[SOV]),
+		},
+		Countries:  make(map[string]map[string]int),
+		Transcript: g.Transcript,
+		Happened:   []happenedJSON{},
+	}
+	for _, e := range g.Happened {
+		gj.Happened = append(gj.Happened, happenedJSON{e.Turn, e.AR, e.Player.Ref(), e.Kind, e.What, e.Success})
+	}
+	for id, aff := range g.SREvents {
+		gj.SREvents[id.Ref()] = aff.Ref()
+	}
+	for _, aff := range []Aff{USA, SOV} {
+		abilities := []string{}
+		for a, on := range g.TurnAbilities[aff] {
+			if on {
+				abilities = append(abilities, a.Ref())
+			}
+		}
+		sort.Strings(abilities)
+		gj.TurnAbilities[aff.Ref()] = abilities
+	}
+	for _, c := range g.Countries {
+		gj.Countries[c.Ref()] = bySide([2]int(c.Inf))
+	}
+	return json.MarshalIndent(gj, "", "  ")
+}
+
+// UnmarshalJSON sets the game to the state in b. The game's countries are
+// updated in place; a game without countries is first set up as a new game.
+func (g *Game) UnmarshalJSON(b []byte) (err error) {
+	var gj gameJSON
+	if err = json.Unmarshal(b, &gj); err != nil {
+		return
+	}
+	if g.Countries == nil {
+		*g = *NewGame()
+	}
+	fail := func(what string, err error) error {
+		return fmt.Errorf("Bad %s in game: %s", what, err.Error())
+	}
+	sides := func(m map[string]int, into *[2]int) error {
+		for ref, n := range m {
+			aff, err := lookupAff(ref)
+			if err != nil {
+				return err
+			}
+			into[aff] = n
+		}
+		return nil
+	}
+	deck := func(refs []string) (*Deck, error) {
+		d := NewDeck()
+		for _, ref := range refs {
+			card, err := lookupCard(ref)
+			if err != nil {
+				return nil, err
+			}
+			d.Push(card)
+		}
+		return d, nil
+	}
+	events := func(refs map[string]string) (map[CardId]Aff, error) {
+		m := make(map[CardId]Aff)
+		for cardRef, affRef := range refs {
+			card, err := lookupCard(cardRef)
+			if err != nil {
+				return nil, err
+			}
+			if m[card.Id], err = lookupAff(affRef); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	g.Turn, g.AR, g.VP, g.Defcon = gj.Turn, gj.AR, gj.VP, gj.Defcon
+	if g.Phasing, err = lookupAff(gj.Phasing); err != nil {
+		return fail("phasing", err)
+	}
+	g.MilOps, g.SpaceRace, g.SpaceAttempts = [2]int{}, [2]int{}, [2]int{}
+	if err = sides(gj.MilOps, &g.MilOps); err != nil {
+		return fail("milops", err)
+	}
+	if err = sides(gj.SpaceRace, &g.SpaceRace); err != nil {
+		return fail("space race", err)
+	}
+	if err = sides(gj.SpaceAttempts, &g.SpaceAttempts); err != nil {
+		return fail("space attempts", err)
+	}
+	if g.ChinaCardPlayer, err = lookupAff(gj.China.Player); err != nil {
+		return fail("china card", err)
+	}
+	g.ChinaCardFaceUp = gj.China.FaceUp
+	g.ChernobylRegion = Region{}
+	if gj.Chernobyl != "" {
+		if g.ChernobylRegion, err = lookupRegion(gj.Chernobyl); err != nil {
+			return fail("chernobyl", err)
+		}
+	}
+	if g.Events, err = events(gj.Events); err != nil {
+		return fail("events", err)
+	}
+	if g.TurnEvents, err = events(gj.TurnEvents); err != nil {
+		return fail("turn events", err)
+	}
+	g.SREvents = make(map[SpaceId]Aff)
+	for idRef, affRef := range gj.SREvents {
+		var id SpaceId
+		if id, err = lookupSpaceId(idRef); err == nil {
+			g.SREvents[id], err = lookupAff(affRef)
+		}
+		if err != nil {
+			return fail("space race events", err)
+		}
+	}
+	g.CancelTurnAbilities()
+	for affRef, refs := range gj.TurnAbilities {
+		var aff Aff
+		if aff, err = lookupAff(affRef); err != nil {
+			return fail("abilities", err)
+		}
+		for _, ref := range refs {
+			var a Ability
+			if a, err = lookupAbility(ref); err != nil {
+				return fail("abilities", err)
+			}
+			g.TurnAbilities[aff][a] = true
+		}
+	}
+	if g.Deck, err = deck(gj.Deck); err != nil {
+		return fail("deck", err)
+	}
+	if g.Discard, err = deck(gj.Discard); err != nil {
+		return fail("discard", err)
+	}
+	if g.Removed, err = deck(gj.Removed); err != nil {
+		return fail("removed", err)
+	}
+	for _, aff := range []Aff{USA, SOV} {
+		if g.Hands[aff], err = deck(gj.Hands[aff.Ref()]); err != nil {
+			return fail("hand", err)
+		}
+	}
+	for ref, inf := range gj.Countries {
+		var c *Country
+		if c, err = lookupCountryIn(g.Countries, ref); err != nil {
+			return fail("countries", err)
+		}
+		var influence [2]int
+		if err = sides(inf, &influence); err != nil {
+			return fail("countries", err)
+		}
+		c.Inf = Influence(influence)
+	}
+	g.Transcript = append([]string{}, gj.Transcript...)
+	g.Happened = []GameEvent{}
+	for _, hj := range gj.Happened {
+		e := GameEvent{Turn: hj.Turn, AR: hj.AR, Kind: hj.Kind, What: hj.What, Success: hj.Success}
+		if e.Player, err = lookupAff(hj.Player); err != nil {
+			return fail("what happened", err)
+		}
+		g.Happened = append(g.Happened, e)
+	}
+	return nil
+}
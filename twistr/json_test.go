@@ -0,0 +1,39 @@
+package twistr
+
+import "encoding/json"
+import "math/rand"
+import "testing"
+
+func TestGameJSONRoundTrip(t *testing.T) {
+	h := &Headless{
+		Agents: [2]Agent{HeuristicBot{}, HeuristicBot{}},
+		Dice:   rand.New(rand.NewSource(1)),
+	}
+	result, err := h.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Entering the Space Shuttle box marks NoAbility.
+	shuttle := NewGame()
+	shuttle.SREvents[NoAbility] = SOV
+	for _, g := range []*Game{NewGame(), shuttle, result.Game} {
+		b, err := json.Marshal(g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded := NewGame()
+		if err := json.Unmarshal(b, loaded); err != nil {
+			t.Fatal(err)
+		}
+		if loaded.Dump() != g.Dump() {
+			t.Errorf("Dump after round trip:\n%s\nwant:\n%s", loaded.Dump(), g.Dump())
+		}
+		again, err := json.Marshal(loaded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(again) != string(b) {
+			t.Errorf("JSON after round trip:\n%s\nwant:\n%s", again, b)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package twistr
+
+import "reflect"
+import "sort"
+import "strconv"
+
+// Legal returns every legal answer to the decision, as it would be logged:
+// the cards that may be chosen, the countries that may take influence or be
+// couped or realigned, the ways a card may be played, and so on. Selections
+// of several countries are decided one country at a time, "end" included
+// where stopping early is allowed. Decisions that cannot be listed, like
+// shuffles or free text, return nil.
+//
+// Each answer is put to the decision's own checks, so the answers are exactly
+// those the engine would accept.
+func (d *Decision) Legal(s *State) []string {
+	legal := []string{}
+	for _, answer := range d.candidates(s) {
+		if d.Check(answer) == nil {
+			legal = append(legal, answer)
+		}
+	}
+	if len(legal) == 0 {
+		return nil
+	}
+	return legal
+}
+
+// Members returns, for a decision on a list of cards or countries, every item
+// that may be in the list on its own. Other decisions return nil.
+func (d *Decision) Members(s *State) []string {
+	t := reflect.TypeOf(d.thing)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Slice || len(d.Choices) > 0 {
+		return nil
+	}
+	members := []string{}
+	for _, ref := range kindCandidates(s, t.Elem()) {
+		if ref != EndSelectCountryStr && d.Check("[ "+ref+" ]") == nil {
+			members = append(members, ref)
+		}
+	}
+	if len(members) == 0 {
+		return nil
+	}
+	return members
+}
+
+// candidates returns the answers a decision might be given, legal or not.
+func (d *Decision) candidates(s *State) []string {
+	if len(d.Choices) > 0 {
+		return d.Choices
+	}
+	t := reflect.TypeOf(d.thing)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Slice {
+		return nil
+	}
+	return kindCandidates(s, t)
+}
+
+// kindCandidates returns every value of type t, as it would be logged, if
+// there are few enough to list.
+func kindCandidates(s *State, t reflect.Type) []string {
+	switch valueKind(t) {
+	case "card":
+		ids := []int{}
+		for id := range Cards {
+			ids = append(ids, int(id))
+		}
+		sort.Ints(ids)
+		refs := []string{}
+		for _, id := range ids {
+			refs = append(refs, Cards[CardId(id)].Ref())
+		}
+		return refs
+	case "country":
+		ids := []int{}
+		for id := range s.Countries {
+			ids = append(ids, int(id))
+		}
+		sort.Ints(ids)
+		refs := []string{}
+		for _, id := range ids {
+			refs = append(refs, s.Countries[CountryId(id)].Ref())
+		}
+		return append(refs, EndSelectCountryStr)
+	case "region":
+		refs := []string{}
+		for ref := range regionIdLookup {
+			refs = append(refs, ref)
+		}
+		sort.Strings(refs)
+		return refs
+	case "playkind":
+		return []string{OPS.Ref(), EVENT.Ref(), SPACE.Ref()}
+	case "opskind":
+		return []string{COUP.Ref(), REALIGN.Ref(), INFLUENCE.Ref()}
+	case "aff":
+		return []string{USA.Ref(), SOV.Ref()}
+	case "int":
+		// Dice, and the small counts events ask for.
+		ns := []string{}
+		for n := 0; n <= 20; n++ {
+			ns = append(ns, strconv.Itoa(n))
+		}
+		return ns
+	default:
+		return nil
+	}
+}
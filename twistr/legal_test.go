@@ -0,0 +1,77 @@
+package twistr
+
+import "math/rand"
+import "reflect"
+import "testing"
+
+// legalChecker checks the legal answers to every decision it is asked, then
+// answers as its bot would.
+type legalChecker struct {
+	t   *testing.T
+	bot Agent
+	// How many decisions had their answers listed, and how many were lists.
+	listed, lists int
+}
+
+func (lc *legalChecker) Decide(s *State, d *Decision) string {
+	if d.Random != nil {
+		return lc.bot.Decide(s, d)
+	}
+	legal := d.Legal(s)
+	if legal == nil {
+		t := reflect.TypeOf(d.thing)
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Slice {
+			lc.t.Fatalf("No legal answers to '%s' (%s) in turn %d", d.Message, d.Kind, s.Turn)
+		}
+		lc.lists++
+		return lc.bot.Decide(s, d)
+	}
+	lc.listed++
+	for _, answer := range legal {
+		if err := d.Check(answer); err != nil {
+			lc.t.Fatalf("Legal answer '%s' to '%s' fails its check: %s", answer, d.Message, err.Error())
+		}
+	}
+	return lc.bot.Decide(s, d)
+}
+
+func TestLegalAnswersPassCheck(t *testing.T) {
+	for seed := int64(1); seed <= 3; seed++ {
+		checkers := [2]*legalChecker{
+			{t: t, bot: NewRandomBot(seed)},
+			{t: t, bot: HeuristicBot{}},
+		}
+		h := &Headless{
+			Agents: [2]Agent{checkers[0], checkers[1]},
+			Dice:   rand.New(rand.NewSource(seed)),
+		}
+		if _, err := h.Run(); err != nil {
+			t.Fatalf("Seed %d: %s", seed, err.Error())
+		}
+		if checkers[0].listed == 0 || checkers[1].listed == 0 {
+			t.Errorf("Seed %d: no decisions listed", seed)
+		}
+	}
+}
+
+func TestLegalNilForLists(t *testing.T) {
+	s := rulesState()
+	var cards []Card
+	d := &Decision{Prompt: Prompt{USA, inputKind(&cards), "Choose cards"}, thing: &cards}
+	if legal := d.Legal(s); legal != nil {
+		t.Errorf("Got %v for a list of cards, want nil", legal)
+	}
+	if members := d.Members(s); len(members) != len(Cards) {
+		t.Errorf("Got %d members, want every card", len(members))
+	}
+
+	var c *Country
+	d = &Decision{Prompt: Prompt{USA, inputKind(&c), "Choose a country"}, thing: &c, countries: s.Countries}
+	legal := d.Legal(s)
+	if len(legal) != len(s.Countries)+1 || legal[len(legal)-1] != EndSelectCountryStr {
+		t.Errorf("Got %d answers, want every country and '%s'", len(legal), EndSelectCountryStr)
+	}
+}
@@ -1,8 +1,9 @@
 package twistr
 
 import "bufio"
+import "crypto/sha256"
+import "encoding/hex"
 import "io"
-import "log"
 import "strings"
 
 type CmdOut struct {
@@ -39,18 +40,35 @@ func (c *CmdOut) Commit() string {
 	if len(contents) == 0 {
 		return ""
 	}
-	log.Printf("Committing to remote: %s\n", contents)
+	debugf("net", "Committing to remote: %s\n", contents)
 	_, err := c.w.Write([]byte(contents + "\n"))
 	if err != nil {
-		log.Println(err)
+		errorf("%s", err)
 	}
 	c.inputs = []string{}
 	return contents
 }
 
+// Send writes a control line to the peer immediately, bypassing the input
+// buffer.
+func (c *CmdOut) Send(line string) {
+	if _, err := c.w.Write([]byte(line + "\n")); err != nil {
+		errorf("%s", err)
+	}
+}
+
+// Control lines are exchanged between peers alongside inputs, but are never
+// part of the game's history.
+const controlPrefix = "$ "
+
+func isControl(line string) bool {
+	return strings.HasPrefix(line, controlPrefix)
+}
+
 type CmdIn struct {
 	*bufio.Scanner
 	Inputs     chan string
+	Control    chan string
 	KillSwitch chan bool
 }
 
@@ -58,6 +76,7 @@ func NewCmdIn(scanner *bufio.Scanner) *CmdIn {
 	ci := &CmdIn{
 		Scanner: scanner,
 		Inputs:  make(chan string, 1000),
+		Control: make(chan string, 1000),
 	}
 	go ci.consume()
 	return ci
@@ -68,14 +87,19 @@ func (ci *CmdIn) consume() {
 	for ci.Scan() {
 		select {
 		case <-ci.KillSwitch:
-			log.Println("LinkIn received the done signal")
+			infof("LinkIn received the done signal")
 			return
 		default:
-			ci.Inputs <- ci.Text()
+			line := ci.Text()
+			if isControl(line) {
+				ci.Control <- line
+			} else {
+				ci.Inputs <- line
+			}
 		}
 	}
 	if err := ci.Err(); err != nil {
-		log.Printf("Error after exhausting the CmdIn: %s\n", err.Error())
+		warnf("Error after exhausting the CmdIn: %s\n", err.Error())
 	}
 }
 
@@ -114,9 +138,9 @@ func NewHistory(ui UI) *History {
 }
 
 func (r *History) Dump() {
-	log.Printf(">>> DUMP\nindex:     %d\nwatermark: %d\n", r.index, r.watermark)
+	infof(">>> DUMP\nindex:     %d\nwatermark: %d\n", r.index, r.watermark)
 	for i, l := range r.inputs {
-		log.Printf("%3d: %s\n", i, l)
+		infof("%3d: %s\n", i, l)
 	}
 }
 
@@ -189,10 +213,50 @@ func (r *History) InReplay() bool {
 func (r *History) Commit() string {
 	buffered := strings.Join(r.inputs[r.watermark:], "\n")
 	r.watermark = r.index
-	log.Printf("Watermarked at %d\n", r.index)
+	debugf("commit", "Watermarked at %d\n", r.index)
 	return buffered
 }
 
+// Index is the number of inputs replayed or played so far.
+func (r *History) Index() int {
+	return r.index
+}
+
+// Played returns the inputs replayed or played so far.
+func (r *History) Played() []string {
+	return append([]string{}, r.inputs[:r.index]...)
+}
+
+// Skip moves replay forward to input n, for resuming from a snapshot taken
+// there.
+func (r *History) Skip(n int) {
+	if n > r.index && n <= len(r.inputs) {
+		r.index = n
+	}
+}
+
+// Digest returns a hash of the first n inputs, or "" if there are fewer than n.
+func (r *History) Digest(n int) string {
+	if n > len(r.inputs) {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(strings.Join(r.inputs[:n], "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Reset replaces the inputs with inputs, and replays them from the start.
+func (r *History) Reset(inputs []string) {
+	r.inputs = append([]string{}, inputs...)
+	r.index = 0
+	r.watermark = len(r.inputs)
+	r.Replaying = true
+}
+
+// Extend adds an input to the end of the history, to be replayed next.
+func (r *History) Extend(input string) {
+	r.inputs = append(r.inputs, input)
+}
+
 func (r *History) CanPop() bool {
 	return len(r.inputs) > r.watermark
 }
@@ -212,11 +276,11 @@ func (r *History) Write(input []byte) (n int, err error) {
 	// Never do when replaying. This means s.Log is safe to call on replayed
 	// input.
 	if r.InReplay() {
-		log.Printf("History not writing %s, in replay (InReplay %v, Replaying %v\n", input, r.InReplay(), r.Replaying)
+		debugf("replay", "History not writing %s, in replay (InReplay %v, Replaying %v\n", input, r.InReplay(), r.Replaying)
 		return
 	}
 	lines := inputLines(string(input))
-	log.Printf("History writing %s\n", lines)
+	debugf("replay", "History writing %s\n", lines)
 	if len(lines) == 0 {
 		return
 	}
@@ -0,0 +1,120 @@
+package twistr
+
+import "fmt"
+import "io"
+import "log"
+import "os"
+import "strings"
+
+// Level is how important a log line is. Lines below the log level are
+// dropped.
+type Level int
+
+const (
+	LogDebug Level = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LogDebug:
+		return "DEBUG"
+	case LogInfo:
+		return "INFO"
+	case LogWarn:
+		return "WARN"
+	case LogError:
+		return "ERROR"
+	default:
+		return "?"
+	}
+}
+
+func ParseLevel(name string) (Level, error) {
+	for _, l := range []Level{LogDebug, LogInfo, LogWarn, LogError} {
+		if strings.EqualFold(name, l.String()) {
+			return l, nil
+		}
+	}
+	return LogInfo, fmt.Errorf("Unknown log level '%s'", name)
+}
+
+var (
+	logLevel = LogInfo
+	// Areas of the engine with debug logging on regardless of the log level.
+	traces = make(map[string]bool)
+)
+
+func SetLogLevel(l Level) {
+	logLevel = l
+}
+
+// Trace turns on debug logging for areas of the engine, given as a comma
+// separated list. The areas are:
+//
+//	replay  History replay
+//	commit  commits and AOF writes
+//	input   inputs read and logged
+//	deck    draws and shuffles
+//	ops     ops modifiers and influence placement
+//	net     lines sent to the peer
+func Trace(areas string) {
+	for _, area := range strings.Split(areas, ",") {
+		if area = strings.TrimSpace(area); area != "" {
+			traces[area] = true
+		}
+	}
+}
+
+func logf(l Level, format string, args ...interface{}) {
+	log.Output(3, l.String()+" "+fmt.Sprintf(format, args...))
+}
+
+func debugf(area, format string, args ...interface{}) {
+	if logLevel <= LogDebug || traces[area] {
+		logf(LogDebug, "["+area+"] "+format, args...)
+	}
+}
+
+func infof(format string, args ...interface{}) {
+	if logLevel <= LogInfo {
+		logf(LogInfo, format, args...)
+	}
+}
+
+func warnf(format string, args ...interface{}) {
+	if logLevel <= LogWarn {
+		logf(LogWarn, format, args...)
+	}
+}
+
+func errorf(format string, args ...interface{}) {
+	logf(LogError, format, args...)
+}
+
+// matchLog is a match's own log file, copied to until it is closed.
+type matchLog struct {
+	f *os.File
+	// Where the log went before.
+	prev io.Writer
+}
+
+// Close stops copying to the match's log and closes it.
+func (ml *matchLog) Close() error {
+	log.SetOutput(ml.prev)
+	return ml.f.Close()
+}
+
+// openMatchLog copies everything logged from now on to the log file at path,
+// so each match has its own log besides the shared one, until it is closed.
+func openMatchLog(path string) (io.Closer, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	ml := &matchLog{f: f, prev: log.Writer()}
+	log.SetOutput(io.MultiWriter(ml.prev, f))
+	return ml, nil
+}
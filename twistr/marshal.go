@@ -64,15 +64,20 @@ func marshalValue(field reflect.Value, buf *bytes.Buffer) error {
 }
 
 func Unmarshal(line string, c interface{}) (err error) {
+	return unmarshalIn(line, c, Countries)
+}
+
+// unmarshalIn is Unmarshal, looking up the countries named among countries.
+func unmarshalIn(line string, c interface{}, countries map[CountryId]*Country) (err error) {
 	scanner := bufio.NewScanner(strings.NewReader(line))
 	scanner.Split(bufio.ScanWords)
 	// Value of c, dereferencing one pointer if necessary
 	cv := reflect.Indirect(reflect.ValueOf(c))
-	err = unmarshalValue(scanner, cv)
+	err = unmarshalValue(scanner, cv, countries)
 	return
 }
 
-func unmarshalSlice(scanner *bufio.Scanner, field reflect.Value) (err error) {
+func unmarshalSlice(scanner *bufio.Scanner, field reflect.Value, countries map[CountryId]*Country) (err error) {
 	var words []string
 	if words, err = readSlice(scanner); err != nil {
 		return err
@@ -81,7 +86,7 @@ func unmarshalSlice(scanner *bufio.Scanner, field reflect.Value) (err error) {
 	case "country":
 		val := make([]*Country, len(words))
 		for i, word := range words {
-			if val[i], err = lookupCountry(word); err != nil {
+			if val[i], err = lookupCountryIn(countries, word); err != nil {
 				return
 			}
 		}
@@ -111,7 +116,7 @@ func readSlice(scanner *bufio.Scanner) ([]string, error) {
 	return s, errors.New("Did not encounter ending ']' of list")
 }
 
-func unmarshalValue(scanner *bufio.Scanner, v reflect.Value) (err error) {
+func unmarshalValue(scanner *bufio.Scanner, v reflect.Value, countries map[CountryId]*Country) (err error) {
 	if !scanner.Scan() {
 		return fmt.Errorf("Not enough tokens for %s", v.Type().Name())
 	}
@@ -120,18 +125,18 @@ func unmarshalValue(scanner *bufio.Scanner, v reflect.Value) (err error) {
 		if word != "[" {
 			return errors.New("Malformed list input. Expected '['")
 		}
-		if err = unmarshalSlice(scanner, v); err != nil {
+		if err = unmarshalSlice(scanner, v, countries); err != nil {
 			return
 		}
 	} else {
-		if err = unmarshalWord(word, v); err != nil {
+		if err = unmarshalWord(word, v, countries); err != nil {
 			return
 		}
 	}
 	return
 }
 
-func unmarshalWord(word string, v reflect.Value) (err error) {
+func unmarshalWord(word string, v reflect.Value, countries map[CountryId]*Country) (err error) {
 	switch valueKind(v.Type()) {
 	case "string":
 		v.SetString(word)
@@ -143,7 +148,7 @@ func unmarshalWord(word string, v reflect.Value) (err error) {
 		v.SetInt(int64(num))
 	case "country":
 		var country *Country
-		if country, err = lookupCountry(word); err != nil {
+		if country, err = lookupCountryIn(countries, word); err != nil {
 			return err
 		}
 		v.Set(reflect.ValueOf(country))
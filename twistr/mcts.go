@@ -0,0 +1,294 @@
+package twistr
+
+import "encoding/json"
+import "io/ioutil"
+import "math"
+import "math/rand"
+import "strings"
+import "time"
+
+// A Budget bounds how long a bot thinks over each decision. Bots that don't
+// search ignore it.
+type Budget struct {
+	// Playouts per decision. If 0, Time bounds the search instead.
+	Playouts int
+	Time     time.Duration
+}
+
+const (
+	defaultThink = 2 * time.Second
+	defaultDepth = 20
+	// How much UCB1 favours answers tried less.
+	mctsExplore = 0.7
+	// The Evaluate margin worth about three to one odds.
+	mctsScale = 10.0
+)
+
+// MCTSBot searches for its answers by information-set Monte Carlo tree
+// search. Each playout plays a copy of the game in a simulation, deals the
+// opponent a hand at random from the cards the bot cannot see, and plays
+// on: down the tree of answers tried so far, choosing by UCB1, then at
+// random for Depth more decisions or to the end of the turn, where the game
+// is scored by Evaluate. The answer played out most is the one given.
+//
+// The game can only be picked up again at the start of a turn, so each
+// playout copies the game as it stood then, from the latest snapshot, and
+// replays the turn's inputs to reach the decision. A game without snapshots
+// is replayed from its first input each time, and is slow to search.
+type MCTSBot struct {
+	Budget Budget
+	// Decisions played at random past the tree.
+	Depth int
+	rng   *rand.Rand
+}
+
+func NewMCTSBot(budget Budget, seed int64) *MCTSBot {
+	if budget.Playouts == 0 && budget.Time == 0 {
+		budget.Time = defaultThink
+	}
+	return &MCTSBot{Budget: budget, Depth: defaultDepth, rng: rand.New(rand.NewSource(seed))}
+}
+
+func (b *MCTSBot) Decide(s *State, d *Decision) string {
+	if d.Random != nil {
+		return d.Auto()
+	}
+	legal := d.Legal(s)
+	switch {
+	case legal == nil:
+		// Lists can't be searched one answer at a time.
+		return HeuristicBot{}.Decide(s, d)
+	case len(legal) == 1:
+		return legal[0]
+	}
+	sr := &search{
+		player:  d.Player,
+		turn:    s.Turn,
+		inputs:  s.History.Played(),
+		root:    &mctsNode{},
+		depth:   b.Depth,
+		rng:     b.rng,
+		rollout: NewRandomBot(b.rng.Int63()),
+	}
+	if s.Snapshots != nil {
+		if snap := s.Snapshots.latest(s.History); snap != nil {
+			sr.start, sr.startInputs = snap.Game, snap.Inputs
+		}
+	}
+	start := time.Now()
+	for n := 0; b.more(n, start); n++ {
+		sr.playout()
+	}
+	return sr.root.best(legal)
+}
+
+func (b *MCTSBot) more(n int, start time.Time) bool {
+	if b.Budget.Playouts > 0 {
+		return n < b.Budget.Playouts
+	}
+	return time.Since(start) < b.Budget.Time
+}
+
+// A search is one decision's worth of playouts.
+type search struct {
+	player Aff
+	// Playouts stop when this turn is over.
+	turn   int
+	inputs []string
+	// The game at the start of the turn, as Game.MarshalJSON writes it, and
+	// the inputs played before it; nil to play from the first input.
+	start       json.RawMessage
+	startInputs int
+	root        *mctsNode
+	depth       int
+	rng         *rand.Rand
+	// Answers for the decisions past the tree.
+	rollout Agent
+}
+
+// A mctsNode is an answer in the tree, with how it has fared.
+type mctsNode struct {
+	answer string
+	// Who gave the answer.
+	player Aff
+	// How often the answer was played out, how often it could have been, and
+	// the rewards of its playouts for player, from 0 for a loss to 1 for a
+	// win.
+	visits   int
+	avail    int
+	reward   float64
+	children map[string]*mctsNode
+}
+
+// child chooses the answer to play out next, of the legal answers that follow
+// n. It returns true if the answer is new to the tree.
+func (n *mctsNode) child(player Aff, legal []string, rng *rand.Rand) (*mctsNode, bool) {
+	if n.children == nil {
+		n.children = make(map[string]*mctsNode)
+	}
+	untried := []string{}
+	for _, answer := range legal {
+		if c, ok := n.children[answer]; ok && c.visits > 0 {
+			c.avail++
+		} else {
+			untried = append(untried, answer)
+		}
+	}
+	if len(untried) > 0 {
+		answer := untried[rng.Intn(len(untried))]
+		c := &mctsNode{answer: answer, player: player, avail: 1}
+		n.children[answer] = c
+		return c, true
+	}
+	var best *mctsNode
+	bestScore := 0.0
+	for _, answer := range legal {
+		c := n.children[answer]
+		score := c.reward/float64(c.visits) + mctsExplore*math.Sqrt(math.Log(float64(c.avail))/float64(c.visits))
+		if best == nil || score > bestScore {
+			best, bestScore = c, score
+		}
+	}
+	return best, false
+}
+
+// best returns the legal answer played out most.
+func (n *mctsNode) best(legal []string) string {
+	best, visits := legal[0], -1
+	for _, answer := range legal {
+		if c, ok := n.children[answer]; ok && c.visits > visits {
+			best, visits = answer, c.visits
+		}
+	}
+	return best
+}
+
+// playout plays the game out once from the decision searched, and credits
+// the answers on the way with the result.
+func (sr *search) playout() {
+	history := NewHistory(NullUI{})
+	history.Reset(sr.inputs)
+	game := NewGame()
+	if sr.start != nil {
+		if err := json.Unmarshal(sr.start, game); err != nil {
+			panic(err)
+		}
+		history.Skip(sr.startInputs)
+	}
+	sim := NewState(history, game, true, USA, ioutil.Discard)
+	sim.LinkOut = NewCmdOut(ioutil.Discard)
+	sim.dice = sr.rng
+	sim.headless = true
+	p := &playout{search: sr, node: sr.root, left: sr.depth}
+	// Neutral decisions go to the server's side, which is either.
+	sim.Agents = [2]Agent{p, p}
+	reward := p.run(sim, sr.start != nil)
+	for _, n := range p.path {
+		n.visits++
+		if n.player == sr.player {
+			n.reward += reward
+		} else {
+			n.reward += 1 - reward
+		}
+	}
+}
+
+// A playout answers both sides' decisions in a simulation.
+type playout struct {
+	search *search
+	// Where the playout is in the tree; nil once past it.
+	node *mctsNode
+	path []*mctsNode
+	// Decisions left to play past the tree.
+	left int
+	// Set once the game has been replayed up to the decision searched.
+	dealt bool
+}
+
+// run plays the simulation out, from the start of the turn if resumed,
+// returning its reward for the searching player.
+func (p *playout) run(s *State, resumed bool) (reward float64) {
+	player := p.search.player
+	defer func() {
+		x := recover()
+		switch x := x.(type) {
+		case nil:
+		case previewEnd:
+			if x.over {
+				reward = mctsReward(outcomeValue(x.victor, player))
+			} else {
+				reward = mctsReward(Evaluate(s.Game, player))
+			}
+		default:
+			panic(x)
+		}
+	}()
+	if resumed {
+		playTurns(s)
+	} else {
+		Start(s)
+	}
+	// Played past the last turn, so the VP decide it.
+	victor := NEU
+	switch {
+	case s.VP > 0:
+		victor = USA
+	case s.VP < 0:
+		victor = SOV
+	}
+	return mctsReward(outcomeValue(victor, player))
+}
+
+func mctsReward(v float64) float64 {
+	return 1 / (1 + math.Exp(-v/mctsScale))
+}
+
+func (p *playout) Decide(s *State, d *Decision) string {
+	sr := p.search
+	if !p.dealt {
+		// History has run out: this is the decision searched.
+		p.dealt = true
+		sr.deal(s, d)
+	}
+	if d.Random != nil {
+		return d.Auto()
+	}
+	if s.Turn > sr.turn {
+		panic(previewEnd{})
+	}
+	if p.node == nil {
+		if p.left--; p.left < 0 {
+			panic(previewEnd{})
+		}
+		return sr.rollout.Decide(s, d)
+	}
+	legal := d.Legal(s)
+	if len(legal) < 2 {
+		return sr.rollout.Decide(s, d)
+	}
+	next, added := p.node.child(d.Player, legal, sr.rng)
+	p.path = append(p.path, next)
+	p.node = next
+	if added {
+		p.node = nil
+	}
+	return next.answer
+}
+
+// deal gives the opponent a hand at random from the cards the searching
+// player cannot see: the opponent's hand and the draw pile.
+func (sr *search) deal(s *State, d *Decision) {
+	before := strings.Join(d.Legal(s), " ")
+	opp := s.Hands[sr.player.Opp()]
+	hand, deck := opp.Cards, s.Deck.Cards
+	pool := append(append([]Card{}, hand...), deck...)
+	sr.rng.Shuffle(len(pool), func(i, j int) {
+		pool[i], pool[j] = pool[j], pool[i]
+	})
+	n := len(hand)
+	opp.Cards, s.Deck.Cards = pool[:n:n], pool[n:]
+	if strings.Join(d.Legal(s), " ") != before {
+		// The decision is on the opponent's hand, which must be in view.
+		opp.Cards, s.Deck.Cards = hand, deck
+	}
+}
@@ -3,41 +3,57 @@ package twistr
 
 import "bufio"
 import "bytes"
+import "crypto/hmac"
+import "encoding/hex"
 import "fmt"
 import "io"
 import "io/ioutil"
-import "log"
 import "net"
 import "os"
 import "os/user"
 import "path/filepath"
+import "regexp"
 import "strings"
 
 var (
+	// Where games, logs and the rest are saved. Set it with SetDataDir.
 	DataDir string
 )
 
-func init() {
-	u, err := user.Current()
-	if err != nil {
-		panic(err.Error())
+// DefaultDataDir is $TWISTR_DATA if set, and otherwise ~/.twistr. Without a
+// home directory, it falls back to .twistr under the working directory.
+func DefaultDataDir() string {
+	if dir := os.Getenv("TWISTR_DATA"); dir != "" {
+		return dir
+	}
+	if u, err := user.Current(); err == nil && u.HomeDir != "" {
+		return filepath.Join(u.HomeDir, ".twistr")
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".twistr")
 	}
-	DataDir = filepath.Join(u.HomeDir, ".twistr")
-	if err := os.MkdirAll(DataDir, os.ModePerm); err != nil {
-		panic(err)
+	return ".twistr"
+}
+
+// SetDataDir sets DataDir, creating it if need be.
+func SetDataDir(dir string) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
 	}
+	DataDir = dir
+	return nil
 }
 
 func acceptGuest(port int) (conn net.Conn, err error) {
 	var ln net.Listener
 	ln, err = net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
-		log.Printf("Error listening on %d: %s", port, err.Error())
+		errorf("Error listening on %d: %s", port, err.Error())
 		return
 	}
 	conn, err = ln.Accept()
 	if err != nil {
-		log.Printf("Error accepting conn: %s", err.Error())
+		errorf("Error accepting conn: %s", err.Error())
 	}
 	return
 }
@@ -45,32 +61,44 @@ func acceptGuest(port int) (conn net.Conn, err error) {
 func connectToHost(url string) (conn net.Conn, err error) {
 	conn, err = net.Dial("tcp", url)
 	if err != nil {
-		log.Printf("Error connecting to host: %s", err.Error())
+		errorf("Error connecting to host: %s", err.Error())
 	}
 	return
 }
 
-func loadAof(aofPath string) ([]byte, error) {
+// loadAof reads the AOF's lines and verifies its chain, with the key kept
+// beside it. A missing AOF is just a new game.
+func loadAof(aofPath string) ([]string, error) {
 	in, err := os.Open(aofPath)
 	if err != nil {
 		if !os.IsNotExist(err) {
 			return nil, fmt.Errorf("Error reading existing AOF: %s", err.Error())
 		}
-		return nil, nil
-	} else {
-		b := new(bytes.Buffer)
-		if _, err := io.Copy(b, in); err != nil {
-			return nil, fmt.Errorf("Error copying aof bytes to buffer: %s", err.Error())
-		}
-		if err := in.Close(); err != nil {
-			return nil, fmt.Errorf("Error closing aof: %s", err.Error())
-		}
-		return b.Bytes(), nil
+		return []string{}, nil
+	}
+	defer in.Close()
+	b := new(bytes.Buffer)
+	if _, err := io.Copy(b, in); err != nil {
+		return nil, fmt.Errorf("Error copying aof bytes to buffer: %s", err.Error())
+	}
+	lines := strings.Split(b.String(), "\n")
+	key, err := readAofKey(aofPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, _, err := parseAof(lines, key); err != nil {
+		return nil, fmt.Errorf("Refusing to load %s: %s", aofPath, err.Error())
 	}
+	return lines, nil
 }
 
+// ValidName matches the names games may be saved under.
+var ValidName = regexp.MustCompile(`^[a-z0-9-$_.]+$`)
+
 type Match struct {
-	UI      UI
+	UI UI
+	// Address of the host, for the guest to connect to.
+	Addr    string
 	Port    int
 	Name    string
 	Game    *Game
@@ -78,24 +106,106 @@ type Match struct {
 	closers []io.Closer
 	Who     Aff
 	Conn    net.Conn
+	Header  *AofHeader
+	// The game's secret, which the AOF's chain is keyed with.
+	Key []byte
+	// Names of the local player and of the opponent
+	Player   string
+	Opponent string
 	// Connected? Synced?
-	// Peer address / ports?
 }
 
 func NewMatch(ui UI) *Match {
 	return &Match{
 		UI:      ui,
+		Addr:    "localhost",
 		Port:    1550,
 		Game:    NewGame(),
-		closers: []io.Closer{}}
+		closers: []io.Closer{},
+		Player:  localPlayerName(),
+	}
+}
+
+func localPlayerName() string {
+	u, err := user.Current()
+	if err != nil || !ValidName.MatchString(u.Username) {
+		return "anonymous"
+	}
+	return u.Username
+}
+
+// Players returns the players' names, indexed by side.
+func (m *Match) Players() (players [2]string) {
+	players[m.Who] = m.Player
+	players[m.Who.Opp()] = m.Opponent
+	return
 }
 
 func (m *Match) AofPath() string {
 	return fmt.Sprintf("%s.aof", filepath.Join(DataDir, m.Name))
 }
 
-func (m *Match) Start() error {
-	log.Println("Starting")
+// GuestAofPath is where the guest keeps its own copy of the AOF. It lives
+// apart from the host's so that both can share a DataDir.
+func (m *Match) GuestAofPath() string {
+	return fmt.Sprintf("%s.aof", filepath.Join(DataDir, "guest", m.Name))
+}
+
+func (m *Match) DesyncPath() string {
+	return fmt.Sprintf("%s.desync", filepath.Join(DataDir, m.Name))
+}
+
+// openSnapshots loads the snapshots kept next to the AOF at aofPath that the
+// AOF vouches for. Without them the game is replayed in full, so failing to
+// load them is not fatal.
+func (m *Match) openSnapshots(aofPath string) {
+	lines, err := loadAof(aofPath)
+	if err != nil {
+		warnf("Not using snapshots: %s\n", err.Error())
+		return
+	}
+	snaps, err := LoadSnapshots(SnapshotPath(aofPath), sealedSnapshots(lines))
+	if err != nil {
+		warnf("Not using snapshots: %s\n", err.Error())
+		return
+	}
+	m.closers = append(m.closers, snaps)
+	m.State.Snapshots = snaps
+}
+
+func (m *Match) LogPath() string {
+	return fmt.Sprintf("%s.log", filepath.Join(DataDir, m.Name))
+}
+
+// openLog starts copying the log to the match's own log file at path.
+func (m *Match) openLog(path string) {
+	f, err := openMatchLog(path)
+	if err != nil {
+		warnf("Not keeping a match log: %s\n", err.Error())
+		return
+	}
+	m.closers = append(m.closers, f)
+}
+
+// Start plays the game. It returns an error if the game halts or its history
+// is invalid, and io.EOF if the UI runs out of input.
+func (m *Match) Start() (err error) {
+	infof("Starting")
+	defer func() {
+		x := recover()
+		switch x := x.(type) {
+		case nil:
+		case halted:
+			err = fmt.Errorf("Game halted: %s", x.msg)
+		case *InvalidHistory:
+			err = x
+		default:
+			if x != io.EOF {
+				panic(x)
+			}
+			err = io.EOF
+		}
+	}()
 	Start(m.State)
 	return nil
 }
@@ -108,6 +218,8 @@ func (m *Match) Close() {
 
 type HostMatch struct {
 	*Match
+	GuestFeed *bufio.Scanner
+	Aof       []string
 }
 
 func NewHostMatch(ui UI, name string, who Aff) *HostMatch {
@@ -120,70 +232,167 @@ func NewHostMatch(ui UI, name string, who Aff) *HostMatch {
 }
 
 func (h *HostMatch) Run() (err error) {
+	h.openLog(h.LogPath())
 	return h.Connect()
 }
 
 func (h *HostMatch) Connect() (err error) {
-	log.Println("Host connecting")
+	infof("Host connecting")
 	h.Conn, err = acceptGuest(h.Port)
 	if err == nil {
 		h.closers = append(h.closers, h.Conn)
-		log.Println("Host connected")
+		infof("Host connected")
 	} else {
-		log.Printf("Failed to connect to guest: %s\n", err.Error())
+		errorf("Failed to connect to guest: %s\n", err.Error())
 		return
 	}
+	h.GuestFeed = bufio.NewScanner(h.Conn)
+	if !h.GuestFeed.Scan() || !strings.HasPrefix(h.GuestFeed.Text(), "$ PLAYER ") {
+		err = fmt.Errorf("Guest did not introduce itself: %v", h.GuestFeed.Err())
+		errorf("%s", err.Error())
+		return
+	}
+	h.Opponent = strings.TrimPrefix(h.GuestFeed.Text(), "$ PLAYER ")
 	return h.Sync()
 }
 
 func (h *HostMatch) Sync() (err error) {
-	if _, err = h.Conn.Write([]byte("$ BEGIN AOF\n")); err != nil {
-		log.Printf("Failed to send aof header: %s\n", err.Error())
+	if err = h.adoptGuestAof(); err != nil {
+		errorf("%s", err.Error())
 		return
 	}
-	var in io.Reader
-	// Don't we need to close this file?
-	in, err = os.Open(h.AofPath())
-	if err != nil {
-		if !os.IsNotExist(err) {
-			log.Printf("Failed to open aof to sync ... %s\n", err.Error())
-			return
-		}
-	} else {
-		log.Println("Host syncing aof")
-		if _, err = io.Copy(h.Conn, in); err != nil {
-			log.Printf("Failed to sync aof to guest ... %s\n", err.Error())
-			return
+	if err = h.prepareAof(); err != nil {
+		errorf("%s", err.Error())
+		return
+	}
+	if h.Aof, err = loadAof(h.AofPath()); err != nil {
+		errorf("%s", err.Error())
+		return
+	}
+	if h.Key, err = readAofKey(h.AofPath()); err != nil {
+		errorf("%s", err.Error())
+		return
+	}
+	infof("Host syncing aof")
+	w := bufio.NewWriter(h.Conn)
+	fmt.Fprintf(w, "$ GAME %s %s\n", h.Name, h.Who.Opp().Ref())
+	if h.Key != nil {
+		fmt.Fprintf(w, "$ KEY %s\n", hex.EncodeToString(h.Key))
+	}
+	fmt.Fprintln(w, "$ BEGIN AOF")
+	for _, line := range h.Aof {
+		if line != "" {
+			fmt.Fprintln(w, line)
 		}
 	}
-	if _, err = h.Conn.Write([]byte("$ END AOF\n")); err != nil {
-		log.Printf("Failed to send aof footer: %s\n", err.Error())
+	fmt.Fprintln(w, "$ END AOF")
+	if err = w.Flush(); err != nil {
+		errorf("Failed to sync aof to guest ... %s\n", err.Error())
 		return
 	}
-	log.Printf("Host synced aof")
+	infof("Host synced aof")
 	return h.Setup()
 }
 
-func (h *HostMatch) Setup() error {
+// adoptGuestAof lets a former guest host the game when the original host is
+// gone: with no AOF of our own, we resume from our guest copy. The copy must
+// verify, and must be of a game we played as guest on the side we now host.
+func (h *HostMatch) adoptGuestAof() error {
+	if _, err := os.Stat(h.AofPath()); !os.IsNotExist(err) {
+		return nil
+	}
+	if _, err := os.Stat(h.GuestAofPath()); os.IsNotExist(err) {
+		return nil
+	}
+	header, _, err := ValidateAof(h.GuestAofPath())
+	switch {
+	case err != nil:
+		return fmt.Errorf("Not hosting from guest copy %s: %s", h.GuestAofPath(), err.Error())
+	case header == nil:
+		return fmt.Errorf("Not hosting from guest copy %s: it has no header", h.GuestAofPath())
+	case header.Host != h.Who.Opp():
+		return fmt.Errorf("Not hosting from guest copy %s: we played it as %s, not %s", h.GuestAofPath(), header.Host.Opp(), h.Who)
+	case header.Players[h.Who] != h.Player:
+		return fmt.Errorf("Not hosting from guest copy %s: %s played it as %s, not %s", h.GuestAofPath(), header.Players[h.Who], h.Who, h.Player)
+	}
+	b, err := ioutil.ReadFile(h.GuestAofPath())
+	if err != nil {
+		return err
+	}
+	key, err := readAofKey(h.GuestAofPath())
+	if err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("No AOF of our own for %s; hosting from our copy as guest, %s.", h.Name, h.GuestAofPath())
+	infof("%s\n", msg)
+	h.UI.Message(msg)
+	if key != nil {
+		if err := writeAofKey(h.AofPath(), key); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(h.AofPath(), b, 0666)
+}
+
+// prepareAof starts the AOF of a new game, making the game's secret, or
+// migrates the AOF of a game started by an older twistr, so that it has a
+// header.
+func (m *Match) prepareAof() error {
+	header, err := ReadAofHeader(m.AofPath())
+	switch {
+	case os.IsNotExist(err):
+		key, err := newAofKey()
+		if err != nil {
+			return err
+		}
+		_, err = writeAof(m.AofPath(), NewAofHeader(m.Who, m.Players(), RNGLive), key, nil)
+		return err
+	case err != nil:
+		return err
+	case header == nil:
+		return MigrateAof(m.AofPath(), NewAofHeader(m.Who, m.Players(), RNGLive))
+	default:
+		return nil
+	}
+}
+
+// resume sets up the state to replay lines, the verified AOF of a game we
+// keep the AOF of, and to carry on writing the AOF from there.
+func (m *Match) resume(lines []string) error {
 	// In
-	b, err := loadAof(h.AofPath())
+	key, err := readAofKey(m.AofPath())
+	if err != nil {
+		return err
+	}
+	header, inputs, last, err := parseAof(lines, key)
 	if err != nil {
 		return err
 	}
+	m.Key = key
+	m.Header = header
 	var history *History
-	if len(b) > 0 {
-		history = NewHistoryBacklog(h.UI, strings.Split(string(b), "\n"))
+	if len(inputs) > 0 {
+		history = NewHistoryBacklog(m.UI, inputs)
 	} else {
-		history = NewHistory(h.UI)
+		history = NewHistory(m.UI)
 	}
 	// Out
-	out, err := os.OpenFile(h.AofPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	out, err := os.OpenFile(m.AofPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
 	if err != nil {
 		return err
 	}
-	h.closers = append(h.closers, out)
-	h.State = NewState(history, h.Game, true, h.Who, out)
-	h.State.LinkIn = NewCmdIn(bufio.NewScanner(h.Conn))
+	m.closers = append(m.closers, out)
+	m.State = NewState(history, m.Game, true, m.Who, NewChainWriter(out, last, header.chainKey(key)))
+	m.State.DesyncPath = m.DesyncPath()
+	m.openSnapshots(m.AofPath())
+	return nil
+}
+
+func (h *HostMatch) Setup() error {
+	if err := h.resume(h.Aof); err != nil {
+		return err
+	}
+	h.State.LinkIn = NewCmdIn(h.GuestFeed)
 	h.State.LinkOut = NewCmdOut(h.Conn)
 	return h.Match.Start()
 }
@@ -202,7 +411,7 @@ func NewGuestMatch(ui UI) *GuestMatch {
 }
 
 func (g *GuestMatch) ConnectHost() string {
-	return "localhost"
+	return g.Addr
 }
 
 func (g *GuestMatch) Run() (err error) {
@@ -210,13 +419,17 @@ func (g *GuestMatch) Run() (err error) {
 }
 
 func (g *GuestMatch) Connect() (err error) {
-	log.Println("Guest connecting")
+	infof("Guest connecting")
 	g.Conn, err = connectToHost(fmt.Sprintf("%s:%d", g.ConnectHost(), g.Port))
 	if err == nil {
 		g.closers = append(g.closers, g.Conn)
-		log.Println("Guest connected")
+		infof("Guest connected")
 	} else {
-		log.Printf("Failed to connect to host: %s\n", err.Error())
+		errorf("Failed to connect to host: %s\n", err.Error())
+		return
+	}
+	if _, err = fmt.Fprintf(g.Conn, "$ PLAYER %s\n", g.Player); err != nil {
+		errorf("Failed to introduce ourselves to host: %s\n", err.Error())
 		return
 	}
 	return g.Sync()
@@ -224,13 +437,25 @@ func (g *GuestMatch) Connect() (err error) {
 
 func (g *GuestMatch) Sync() error {
 	// XXX: this is not re-entrant
-	log.Println("Guest receiving aof sync")
+	infof("Guest receiving aof sync")
 	g.HostFeed = bufio.NewScanner(g.Conn)
 	var line string
 ReadAof:
 	for g.HostFeed.Scan() {
 		line = g.HostFeed.Text()
 		switch {
+		case strings.HasPrefix(line, "$ GAME "):
+			if err := g.readGame(line); err != nil {
+				errorf("Bad game handshake: %s\n", err.Error())
+				return err
+			}
+		case strings.HasPrefix(line, "$ KEY "):
+			key, err := hex.DecodeString(strings.TrimPrefix(line, "$ KEY "))
+			if err != nil {
+				errorf("Bad game key: %s\n", err.Error())
+				return err
+			}
+			g.Key = key
 		case line == "$ BEGIN AOF":
 		case line == "$ END AOF":
 			break ReadAof
@@ -239,21 +464,103 @@ ReadAof:
 		}
 	}
 	if err := g.HostFeed.Err(); err != nil {
-		log.Printf("Failed while reading sync ... %s\n", err.Error())
+		errorf("Failed while reading sync ... %s\n", err.Error())
 		return err
 	}
-	log.Println("Guest received aof")
+	infof("Guest received aof")
 	return g.Setup()
 }
 
+// readGame reads the name of the game and our side from the host's handshake.
+func (g *GuestMatch) readGame(line string) error {
+	fields := strings.Fields(strings.TrimPrefix(line, "$ GAME "))
+	if len(fields) != 2 || !ValidName.MatchString(fields[0]) {
+		return fmt.Errorf("Malformed '%s'", line)
+	}
+	g.Name = fields[0]
+	return Unmarshal(fields[1], &g.Who)
+}
+
+// openOwnAof checks the host's inputs against our own copy of the AOF, and
+// opens our copy for writing. Our copy may trail the host's, e.g. if we
+// crashed before writing the last inputs, but must never disagree with it.
+func (g *GuestMatch) openOwnAof(header *AofHeader, inputs []string) (io.Writer, error) {
+	ownKey, err := readAofKey(g.GuestAofPath())
+	if err != nil {
+		return nil, err
+	}
+	if ownKey != nil && !hmac.Equal(ownKey, g.Key) {
+		return nil, fmt.Errorf("Host's key differs from our copy's")
+	}
+	own, err := loadAof(g.GuestAofPath())
+	if err != nil {
+		return nil, err
+	}
+	ownHeader, ownInputs, last, err := parseAof(own, ownKey)
+	if err != nil {
+		return nil, err
+	}
+	if ownHeader != nil && strings.Join(ownHeader.Lines(), "\n") != strings.Join(header.Lines(), "\n") {
+		return nil, fmt.Errorf("Host's aof is for a different game than our copy")
+	}
+	if len(ownInputs) > len(inputs) {
+		return nil, fmt.Errorf("Host's aof is missing %d inputs from our copy", len(ownInputs)-len(inputs))
+	}
+	for i, input := range ownInputs {
+		if inputs[i] != input {
+			return nil, fmt.Errorf("Host's aof differs from our copy at input %d: '%s' vs '%s'", i+1, inputs[i], input)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(g.GuestAofPath()), os.ModePerm); err != nil {
+		return nil, err
+	}
+	if ownHeader == nil {
+		// Either we have no copy yet, or it predates headers; start over
+		// from the host's header.
+		if last, err = writeAof(g.GuestAofPath(), header, g.Key, ownInputs); err != nil {
+			return nil, err
+		}
+	}
+	out, err := os.OpenFile(g.GuestAofPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	g.closers = append(g.closers, out)
+	aof := NewChainWriter(out, last, header.chainKey(g.Key))
+	if missing := inputs[len(ownInputs):]; len(missing) > 0 {
+		if _, err := aof.Write([]byte(strings.Join(missing, "\n"))); err != nil {
+			return nil, err
+		}
+	}
+	return aof, nil
+}
+
 func (g *GuestMatch) Setup() error {
+	header, inputs, _, err := parseAof(g.Aof, g.Key)
+	if err == nil && header == nil {
+		err = fmt.Errorf("No header")
+	}
+	if err != nil {
+		errorf("Refusing the host's aof: %s\n", err.Error())
+		return err
+	}
+	g.Header = header
+	g.Opponent = header.Players[g.Who.Opp()]
+	aof, err := g.openOwnAof(header, inputs)
+	if err != nil {
+		errorf("Refusing the host's aof: %s\n", err.Error())
+		return err
+	}
+	g.openLog(strings.TrimSuffix(g.GuestAofPath(), ".aof") + ".log")
 	var history *History
-	if len(g.Aof) > 0 {
-		history = NewHistoryBacklog(g.UI, g.Aof)
+	if len(inputs) > 0 {
+		history = NewHistoryBacklog(g.UI, inputs)
 	} else {
 		history = NewHistory(g.UI)
 	}
-	g.State = NewState(history, g.Game, false, g.Who, ioutil.Discard)
+	g.State = NewState(history, g.Game, false, g.Who, aof)
+	g.State.DesyncPath = g.DesyncPath()
+	g.openSnapshots(g.GuestAofPath())
 	g.State.LinkIn = NewCmdIn(g.HostFeed)
 	g.State.LinkOut = NewCmdOut(g.Conn)
 	return g.Match.Start()
@@ -0,0 +1,177 @@
+package twistr
+
+import "bufio"
+import "fmt"
+import "io"
+import "reflect"
+import "strconv"
+import "strings"
+
+// A Prompt is what the game asks a player for: who answers, what kind of
+// thing they answer with, and the message they are shown.
+type Prompt struct {
+	Player  Aff
+	Kind    string
+	Message string
+}
+
+// inputKind names the kind of thing read into, e.g. "card" or "country".
+func inputKind(thing interface{}) string {
+	t := reflect.TypeOf(thing)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t.Kind() == reflect.String:
+		return "choice"
+	case t.Kind() == reflect.Slice:
+		return strings.ToLower(t.Elem().Name()) + "s"
+	case t.Kind() == reflect.Int && t.Name() == "int":
+		return "number"
+	default:
+		return strings.ToLower(t.Name())
+	}
+}
+
+// A Move is one AOF input in notation: the input along with where in the game
+// it was given, who gave it and what it answered. In the notation, one move
+// per line,
+//
+//	T1 AR2 ussr card: starwars # Choose a card from your hand.
+//
+// and the headline is AR0. The message after '#' is only for the reader.
+type Move struct {
+	Turn   int
+	AR     int
+	Player Aff
+	Kind   string
+	Input  string
+	// The prompt's message.
+	Message string
+}
+
+func (m Move) String() string {
+	line := fmt.Sprintf("%s: %s", m.context(), m.Input)
+	if m.Message != "" {
+		line += " # " + strings.Replace(m.Message, "\n", " ", -1)
+	}
+	return line
+}
+
+func (m Move) context() string {
+	return fmt.Sprintf("T%d AR%d %s %s", m.Turn, m.AR, m.Player.Ref(), m.Kind)
+}
+
+// ParseMove reads a move from a line of notation.
+func ParseMove(line string) (m Move, err error) {
+	colon := strings.Index(line, ":")
+	if colon < 0 {
+		return m, fmt.Errorf("Missing ':' in '%s'", line)
+	}
+	m.Input = strings.TrimSpace(line[colon+1:])
+	if hash := strings.Index(m.Input, "#"); hash >= 0 {
+		m.Message = strings.TrimSpace(m.Input[hash+1:])
+		m.Input = strings.TrimSpace(m.Input[:hash])
+	}
+	if m.Input == "" {
+		return m, fmt.Errorf("Missing input in '%s'", line)
+	}
+	fields := strings.Fields(line[:colon])
+	if len(fields) != 4 {
+		return m, fmt.Errorf("Expected 'T<turn> AR<ar> <player> <kind>' in '%s'", line)
+	}
+	if !strings.HasPrefix(fields[0], "T") || !strings.HasPrefix(fields[1], "AR") {
+		return m, fmt.Errorf("Bad turn or AR in '%s'", line)
+	}
+	if m.Turn, err = strconv.Atoi(fields[0][1:]); err != nil {
+		return m, fmt.Errorf("Bad turn in '%s'", line)
+	}
+	if m.AR, err = strconv.Atoi(fields[1][2:]); err != nil {
+		return m, fmt.Errorf("Bad AR in '%s'", line)
+	}
+	if m.Player, err = lookupAff(strings.ToLower(fields[2])); err != nil {
+		return m, err
+	}
+	m.Kind = fields[3]
+	return m, nil
+}
+
+// notater hands a game its inputs one at a time, noting the context of each.
+type notater struct {
+	inputs []string
+	moves  []Move
+}
+
+func (n *notater) Pause(s *State) {
+	pos := len(n.moves)
+	if pos == len(n.inputs) {
+		panic(endOfRecord{})
+	}
+	n.moves = append(n.moves, Move{
+		Turn:    s.Turn,
+		AR:      s.AR,
+		Player:  s.Asking.Player,
+		Kind:    s.Asking.Kind,
+		Input:   n.inputs[pos],
+		Message: s.Asking.Message,
+	})
+	s.History.Extend(n.inputs[pos])
+}
+
+func (n *notater) End(s *State) {
+	panic(endOfRecord{})
+}
+
+// Notate replays inputs to put them in notation. If the game breaks down
+// along the way, the moves so far are returned with the error.
+func Notate(inputs []string) ([]Move, error) {
+	n := &notater{inputs: inputs, moves: []Move{}}
+	if _, err := replayHeadless(n); err != nil {
+		return n.moves, fmt.Errorf("Replay failed at input %d: %s", len(n.moves), err.Error())
+	}
+	if len(n.moves) < len(inputs) {
+		return n.moves, fmt.Errorf("Game ended after input %d of %d", len(n.moves), len(inputs))
+	}
+	return n.moves, nil
+}
+
+// MoveInputs strips moves back to the bare inputs of an AOF, after checking
+// each move's context against a replay of the game. A hand-edited move that
+// changes what the game asks for next shows up as the first move whose
+// context no longer matches.
+func MoveInputs(moves []Move) ([]string, error) {
+	inputs := make([]string, len(moves))
+	for i, m := range moves {
+		inputs[i] = m.Input
+	}
+	replayed, err := Notate(inputs)
+	for i, m := range replayed {
+		if m.context() != moves[i].context() {
+			return nil, fmt.Errorf("Move %d '%s' is out of place; the game is at '%s'",
+				i+1, moves[i], m.context())
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return inputs, nil
+}
+
+// ReadMoves reads moves in notation. Blank lines and lines starting with '#'
+// are skipped.
+func ReadMoves(r io.Reader) ([]Move, error) {
+	moves := []Move{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m, err := ParseMove(line)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %s", n, err.Error())
+		}
+		moves = append(moves, m)
+	}
+	return moves, scanner.Err()
+}
@@ -0,0 +1,66 @@
+package twistr
+
+import "math/rand"
+import "path/filepath"
+import "reflect"
+import "strings"
+import "testing"
+
+func TestNotationRoundTrip(t *testing.T) {
+	h := &Headless{
+		Agents: [2]Agent{HeuristicBot{}, NewRandomBot(1)},
+		Dice:   rand.New(rand.NewSource(1)),
+	}
+	played, err := h.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "game.aof")
+	header := NewAofHeader(USA, [2]string{"alice", "bob"}, RNGLive)
+	if _, err := writeAof(path, header, []byte("secret"), played.Inputs); err != nil {
+		t.Fatal(err)
+	}
+	_, inputs, err := ValidateAof(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moves, err := Notate(inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := make([]string, len(moves))
+	for i, m := range moves {
+		lines[i] = m.String()
+		parsed, err := ParseMove(lines[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.context() != m.context() || parsed.Input != m.Input {
+			t.Errorf("Parsed '%s' as '%s'", lines[i], parsed)
+		}
+	}
+	read, err := ReadMoves(strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := MoveInputs(read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, inputs) {
+		t.Errorf("Round trip changed the inputs")
+	}
+	// A move edited into a different kind of input is out of place.
+	for i, m := range read {
+		if m.Kind == "playkind" {
+			read[i].Input = "space"
+			if read[i].Input == m.Input {
+				read[i].Input = "ops"
+			}
+			break
+		}
+	}
+	if _, err := MoveInputs(read); err == nil {
+		t.Error("Read an edited game whose moves are out of place")
+	}
+}
@@ -0,0 +1,202 @@
+package twistr
+
+import "fmt"
+import "strings"
+
+// CoupOdds is what a coup would do on each roll of the die.
+type CoupOdds struct {
+	Player Aff
+	Target *Country
+	// The card's ops against the target, and the mods to the roll besides.
+	Ops  int
+	Mods []Mod
+	// Indexed by the roll less one.
+	Rolls [6]CoupRoll
+	// The chance the coup removes any influence, and how much influence each
+	// side can expect to gain or lose.
+	Success  float64
+	Expected [2]float64
+	// Military operations gained, success or not.
+	MilOps int
+	// DEFCON before and after the coup; below 2, the coup ends the game.
+	Defcon [2]int
+	// What else coupping the target would do, such as end the game by the
+	// Cuban Missile Crisis.
+	Notes []string
+}
+
+// CoupRoll is the result of a coup on one roll.
+type CoupRoll struct {
+	Roll int
+	// The roll, ops and mods less twice the stability.
+	Result int
+	// The opponent's influence removed, and the player's added.
+	Removed int
+	Gained  int
+}
+
+// ComputeCoupOdds works out what player coupping target with card would do,
+// without doing it. A free coup gains no military operations.
+func ComputeCoupOdds(s *State, player Aff, card Card, target *Country, free bool) CoupOdds {
+	odds := CoupOdds{
+		Player: player,
+		Target: target,
+		Ops:    ComputeCardOps(s, player, card, []*Country{target}),
+		Mods:   coupMods(s, player, target),
+		Defcon: [2]int{s.Defcon, s.Defcon},
+	}
+	opp := player.Opp()
+	successes := 0
+	for roll := 1; roll <= 6; roll++ {
+		cr := CoupRoll{Roll: roll}
+		cr.Result, cr.Removed, cr.Gained = coupResult(player, target, roll, odds.Ops, odds.Mods)
+		if cr.Result > 0 {
+			successes++
+		}
+		odds.Rolls[roll-1] = cr
+		odds.Expected[opp] -= float64(cr.Removed) / 6
+		odds.Expected[player] += float64(cr.Gained) / 6
+	}
+	odds.Success = float64(successes) / 6
+	if !free {
+		odds.MilOps = odds.Ops
+	}
+	if target.Battleground && !(s.Effect(NuclearSubs) && player == USA) {
+		odds.Defcon[1]--
+	}
+	if s.Effect(CubanMissileCrisis, opp) {
+		if player == SOV {
+			odds.Notes = append(odds.Notes, "ends the game by the Cuban Missile Crisis, unless the USSR removes 2 influence from Cuba")
+		} else {
+			odds.Notes = append(odds.Notes, "ends the game by the Cuban Missile Crisis, unless the US removes 2 influence from West Germany or Turkey")
+		}
+	}
+	if s.Effect(YuriAndSamantha) && player == USA {
+		odds.Notes = append(odds.Notes, "gives the USSR 1 VP for Yuri and Samantha")
+	}
+	return odds
+}
+
+// Lines describes the odds, roll by roll.
+func (odds CoupOdds) Lines() []string {
+	player, opp, target := odds.Player, odds.Player.Opp(), odds.Target
+	summary := fmt.Sprintf("Coup in %s (stability %d) with %d ops%s: succeeds %s, expected %+.1f %s influence",
+		target, target.Stability, odds.Ops, ModSummary(odds.Mods), chanceIn(odds.Success, 6), odds.Expected[opp], opp)
+	if odds.Expected[player] > 0 {
+		summary += fmt.Sprintf(" and %+.1f %s influence", odds.Expected[player], player)
+	}
+	lines := []string{summary}
+	consequences := []string{}
+	if odds.MilOps > 0 {
+		consequences = append(consequences, fmt.Sprintf("%+d milops", odds.MilOps))
+	}
+	switch after := odds.Defcon[1]; {
+	case after < 2:
+		consequences = append(consequences, fmt.Sprintf("DEFCON to %d: %s loses the game", after, player))
+	case after < odds.Defcon[0]:
+		consequences = append(consequences, fmt.Sprintf("DEFCON to %d", after))
+	}
+	consequences = append(consequences, odds.Notes...)
+	if len(consequences) > 0 {
+		lines = append(lines, "  "+strings.Join(consequences, "; "))
+	}
+	for _, cr := range odds.Rolls {
+		switch {
+		case cr.Result <= 0:
+			lines = append(lines, fmt.Sprintf("  %d: %d, no influence removed", cr.Roll, cr.Result))
+		case cr.Gained > 0:
+			lines = append(lines, fmt.Sprintf("  %d: %d, -%d %s, +%d %s", cr.Roll, cr.Result, cr.Removed, opp, cr.Gained, player))
+		default:
+			lines = append(lines, fmt.Sprintf("  %d: %d, -%d %s", cr.Roll, cr.Result, cr.Removed, opp))
+		}
+	}
+	return lines
+}
+
+// RealignOdds is what a realignment would do over the rolls of the two dice.
+type RealignOdds struct {
+	Player Aff
+	Target *Country
+	// The mods to each side's roll.
+	Mods [2][]Mod
+	// Lost[aff][n] is the chance of aff losing n influence.
+	Lost [2][]float64
+	// The chance the opponent loses any influence, and how much influence
+	// each side can expect to lose.
+	Success  float64
+	Expected [2]float64
+}
+
+// ComputeRealignOdds works out what player realigning target would do,
+// without doing it.
+func ComputeRealignOdds(s *State, player Aff, target *Country) RealignOdds {
+	odds := RealignOdds{Player: player, Target: target}
+	odds.Mods[USA], odds.Mods[SOV] = realignMods(s, target)
+	for _, aff := range []Aff{USA, SOV} {
+		odds.Lost[aff] = make([]float64, target.Inf[aff]+1)
+	}
+	for rollUSA := 1; rollUSA <= 6; rollUSA++ {
+		for rollSOV := 1; rollSOV <= 6; rollSOV++ {
+			lost := realignLoss(target, rollUSA+TotalMod(odds.Mods[USA]), rollSOV+TotalMod(odds.Mods[SOV]))
+			for _, aff := range []Aff{USA, SOV} {
+				odds.Lost[aff][lost[aff]] += 1.0 / 36
+				odds.Expected[aff] -= float64(lost[aff]) / 36
+			}
+		}
+	}
+	odds.Success = 1 - odds.Lost[player.Opp()][0]
+	return odds
+}
+
+// Lines describes the odds, by the influence each side could lose.
+func (odds RealignOdds) Lines() []string {
+	player, opp, target := odds.Player, odds.Player.Opp(), odds.Target
+	lines := []string{fmt.Sprintf("Realign in %s: succeeds %s, expected %+.1f %s influence and %+.1f %s influence",
+		target, chanceIn(odds.Success, 36), odds.Expected[opp], opp, odds.Expected[player], player)}
+	for _, aff := range []Aff{USA, SOV} {
+		if len(odds.Mods[aff]) > 0 {
+			lines = append(lines, fmt.Sprintf("  %s rolls%s", aff, ModSummary(odds.Mods[aff])))
+		}
+	}
+	for _, aff := range []Aff{opp, player} {
+		for n, chance := range odds.Lost[aff] {
+			if n > 0 && chance > 0 {
+				lines = append(lines, fmt.Sprintf("  -%d %s: %s", n, aff, chanceIn(chance, 36)))
+			}
+		}
+	}
+	return lines
+}
+
+// chanceIn describes a chance as so many in n, and as a percentage.
+func chanceIn(chance float64, n int) string {
+	return fmt.Sprintf("%d in %d (%.0f%%)", int(chance*float64(n)+0.5), n, 100*chance)
+}
+
+// ShowOdds shows the odds of the coup or realignment being decided: in the
+// country named, or else in every country that can be targeted.
+func ShowOdds(s *State, args []string) {
+	d := s.Asking
+	if d == nil || d.odds == nil {
+		s.UI.Message("No coup or realignment to show the odds of.")
+		return
+	}
+	targets := args
+	if len(targets) == 0 {
+		targets = d.Legal(s)
+	}
+	lines := []string{}
+	for _, name := range targets {
+		c, err := lookupCountryIn(s.Countries, name)
+		if err != nil {
+			s.UI.Message(err.Error())
+			return
+		}
+		if c == EndSelectCountry {
+			continue
+		}
+		lines = append(lines, d.odds(c)...)
+	}
+	s.Enter(NewLogMode(lines))
+	s.Redraw(s.Game)
+}
@@ -0,0 +1,46 @@
+package twistr
+
+import "testing"
+
+func TestCoupOddsMatchCoup(t *testing.T) {
+	s := rulesState()
+	s.Event(SALTNegotiations, SOV)
+	card := PseudoCard(4)
+	target := s.Countries[Iran]
+	target.Inf[USA], target.Inf[SOV] = 1, 2
+	odds := ComputeCoupOdds(s, USA, card, target, false)
+	for roll := 1; roll <= 6; roll++ {
+		target.Inf[USA], target.Inf[SOV] = 1, 2
+		// Iran is a battleground; keep the coups from ending the game.
+		s.Defcon = 5
+		coup(s, USA, ComputeCardOps(s, USA, card, []*Country{target}), roll, target, false)
+		cr := odds.Rolls[roll-1]
+		if got := [2]int{target.Inf[USA] - 1, 2 - target.Inf[SOV]}; got != [2]int{cr.Gained, cr.Removed} {
+			t.Errorf("Roll %d: coup gained and removed %v, odds say %+v", roll, got, cr)
+		}
+	}
+}
+
+func TestRealignOddsMatchRealign(t *testing.T) {
+	s := rulesState()
+	s.Event(IranContraScandal, SOV)
+	target := s.Countries[Iraq]
+	target.Inf[USA], target.Inf[SOV] = 2, 1
+	odds := ComputeRealignOdds(s, USA, target)
+	lost := [2][]float64{make([]float64, 3), make([]float64, 3)}
+	for rollUSA := 1; rollUSA <= 6; rollUSA++ {
+		for rollSOV := 1; rollSOV <= 6; rollSOV++ {
+			target.Inf[USA], target.Inf[SOV] = 2, 1
+			realign(s, target, rollUSA, rollSOV)
+			lost[USA][2-target.Inf[USA]] += 1.0 / 36
+			lost[SOV][1-target.Inf[SOV]] += 1.0 / 36
+		}
+	}
+	for _, aff := range []Aff{USA, SOV} {
+		for n, chance := range odds.Lost[aff] {
+			if d := chance - lost[aff][n]; d > 1e-9 || d < -1e-9 {
+				t.Errorf("%s losing %d: odds say %.3f, realign %.3f", aff, n, chance, lost[aff][n])
+			}
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package twistr
 
 import "fmt"
-import "log"
 
 // All WIP. Maybe obliterate it.
 
@@ -14,7 +13,8 @@ func Realign(s *State, player Aff, c *Country) {
 	s.Commit()
 }
 
-func realignMods(target Country) (modsUsa []Mod, modsSov []Mod) {
+// realignMods returns the mods to each side's roll to realign target.
+func realignMods(s *State, target *Country) (modsUsa []Mod, modsSov []Mod) {
 	switch {
 	case target.Inf[USA] > target.Inf[SOV]:
 		modsUsa = append(modsUsa, Mod{1, "US influence"})
@@ -37,14 +37,26 @@ func realignMods(target Country) (modsUsa []Mod, modsSov []Mod) {
 	if sovAdj > 0 {
 		modsSov = append(modsSov, Mod{sovAdj, "USSR controlled adjacent"})
 	}
+	if s.Effect(IranContraScandal) {
+		modsUsa = append(modsUsa, Mod{-1, "Iran-Contra Scandal"})
+	}
 	return
 }
 
-func realign(s *State, target *Country, rollUSA, rollSOV int) {
-	modsUsa, modsSov := realignMods(*target)
-	if s.Effect(IranContraScandal) {
-		modsUsa = append(modsUsa, Mod{-1, "Iran-Contra Scandal"})
+// realignLoss returns the influence each side loses in target on rolls, mods
+// included. The lower roll loses the difference.
+func realignLoss(target *Country, rollUSA, rollSOV int) (lost [2]int) {
+	switch {
+	case rollUSA > rollSOV:
+		lost[SOV] = Min(rollUSA-rollSOV, target.Inf[SOV])
+	case rollSOV > rollUSA:
+		lost[USA] = Min(rollSOV-rollUSA, target.Inf[USA])
 	}
+	return
+}
+
+func realign(s *State, target *Country, rollUSA, rollSOV int) {
+	modsUsa, modsSov := realignMods(s, target)
 	if len(modsUsa) > 0 {
 		s.Transcribe(fmt.Sprintf("US rolls %d %s.", rollUSA, ModSummary(modsUsa)))
 	} else {
@@ -55,21 +67,13 @@ func realign(s *State, target *Country, rollUSA, rollSOV int) {
 	} else {
 		s.Transcribe(fmt.Sprintf("USSR rolls %d.", rollSOV))
 	}
-	rollUSA += TotalMod(modsUsa)
-	rollSOV += TotalMod(modsSov)
-	initUSA := target.Inf[USA]
-	initSOV := target.Inf[SOV]
-	switch {
-	case rollUSA > rollSOV:
-		target.Inf[SOV] -= Min((rollUSA - rollSOV), target.Inf[SOV])
-
-	case rollSOV > rollUSA:
-		target.Inf[USA] -= Min((rollSOV - rollUSA), target.Inf[USA])
-	}
-	if initUSA > target.Inf[USA] {
-		s.Transcribe(fmt.Sprintf("%d US influence removed", initUSA-target.Inf[USA]))
-	} else if initSOV > target.Inf[SOV] {
-		s.Transcribe(fmt.Sprintf("%d USSR influence removed", initSOV-target.Inf[SOV]))
+	lost := realignLoss(target, rollUSA+TotalMod(modsUsa), rollSOV+TotalMod(modsSov))
+	target.Inf[USA] -= lost[USA]
+	target.Inf[SOV] -= lost[SOV]
+	if lost[USA] > 0 {
+		s.Transcribe(fmt.Sprintf("%d US influence removed", lost[USA]))
+	} else if lost[SOV] > 0 {
+		s.Transcribe(fmt.Sprintf("%d USSR influence removed", lost[SOV]))
 	} else {
 		s.Transcribe("No influence removed")
 	}
@@ -127,7 +131,7 @@ func opsMods(s *State, player Aff, card Card, countries []*Country) (mods []Mod)
 	case containment && tmpTotal < 4:
 		mods = append(mods, Mod{1, "Containment"})
 	}
-	log.Printf("Computed mods for %s playing %s: %s\n", player, card, ModSummary(mods))
+	debugf("ops", "Computed mods for %s playing %s: %s\n", player, card, ModSummary(mods))
 	return
 }
 
@@ -148,13 +152,25 @@ func Coup(s *State, player Aff, card Card, c *Country, free bool) (success bool)
 	mods := opsMods(s, player, card, []*Country{c})
 	ops := card.Ops + TotalMod(mods)
 	success = coup(s, player, ops, roll, c, free)
+	s.happen(GameEvent{Player: player, Kind: "coup", What: c.Ref(), Success: success})
 	s.Commit()
 	return
 }
 
+// coupResult returns the roll, ops and mods of a coup in target less twice its
+// stability, and the opponent's influence it removes and the player's it adds.
+func coupResult(player Aff, target *Country, roll, ops int, mods []Mod) (result, removed, gained int) {
+	result = roll + TotalMod(mods) + ops - 2*target.Stability
+	if result > 0 {
+		removed = Min(target.Inf[player.Opp()], result)
+		gained = result - removed
+	}
+	return
+}
+
 func coup(s *State, player Aff, ops int, roll int, target *Country, free bool) (removedInfluence bool) {
 	mods := coupMods(s, player, target)
-	delta := roll + TotalMod(mods) + ops - (target.Stability * 2)
+	delta, removed, gained := coupResult(player, target, roll, ops, mods)
 	if len(mods) > 0 {
 		s.Transcribe(fmt.Sprintf("Result: %d +%d (ops) %s -%d (2x stability).", roll, ops, ModSummary(mods), 2*target.Stability))
 	} else {
@@ -162,9 +178,6 @@ func coup(s *State, player Aff, ops int, roll int, target *Country, free bool) (
 	}
 	removedInfluence = delta > 0
 	if removedInfluence {
-		oppCurInf := target.Inf[player.Opp()]
-		removed := Min(oppCurInf, delta)
-		gained := delta - removed
 		target.Inf[player] += gained
 		target.Inf[player.Opp()] -= removed
 		s.Transcribe(fmt.Sprintf("%s %s influence reduced by %d, now %d.", target, player.Opp(), removed, target.Inf[player.Opp()]))
@@ -190,7 +203,7 @@ func coup(s *State, player Aff, ops int, roll int, target *Country, free bool) (
 // A country cannot be coup'd if it lacks any of the opponent's influence.
 // Some permanent events also impose coup restrictions, e.g. NATO with Europe.
 func CanCoup(s *State, player Aff, free bool) countryCheck {
-	return func(t *Country) error {
+	return func(t *Country, _ []*Country) error {
 		switch {
 		case t.Inf[player.Opp()] < 1:
 			return fmt.Errorf("No %s influence in %s", player.Opp(), t.Name)
@@ -209,7 +222,7 @@ func CanCoup(s *State, player Aff, free bool) countryCheck {
 }
 
 func CanRealign(s *State, player Aff, free bool) countryCheck {
-	return func(t *Country) error {
+	return func(t *Country, _ []*Country) error {
 		switch {
 		case natoProtected(s, player, t):
 			return fmt.Errorf("%s protected by NATO", t.Name)
@@ -225,6 +238,20 @@ func CanRealign(s *State, player Aff, free bool) countryCheck {
 	}
 }
 
+// anyCountry returns whether any country passes checks.
+func anyCountry(s *State, checks ...countryCheck) bool {
+Countries:
+	for _, c := range s.Countries {
+		for _, check := range checks {
+			if check(c, nil) != nil {
+				continue Countries
+			}
+		}
+		return true
+	}
+	return false
+}
+
 func defconProtected(s *State, t *Country) bool {
 	// asia 3, defcon 5, not protected
 	// europe 4, defcon 3, protected
@@ -365,56 +392,60 @@ func SelectOneInfluence(s *State, player Aff, message string, change countryChan
 }
 
 func selectInfluence(s *State, player Aff, message string, change countryChange, nFun func([]*Country) int, exactly bool, costFun func(*Country) int, checks ...countryCheck) []*Country {
-	remote := player != s.LocalPlayer
+	return selectTargets(s, player, message, change, nFun, exactly, costFun, nil, checks...)
+}
+
+// selectTargets is selectInfluence for coups and realignments, whose odds
+// the player can ask for on each target.
+func selectTargets(s *State, player Aff, message string, change countryChange, nFun func([]*Country) int, exactly bool, costFun func(*Country) int, odds func(*Country) []string, checks ...countryCheck) []*Country {
 	used := 0
 	chosen := []*Country{}
 	var c *Country
-	var err error
-loop:
-	if err != nil {
-		s.UI.Message(err.Error())
-		err = nil
-	}
-	log.Printf("Reading from %s '%s'\n", player, message)
-	if !s.ReadInto(&c, remote) {
-		localInput(s, &c, message)
-	}
-	cost := costFun(c)
-	n := nFun(append(chosen, c))
-	switch {
-	case c == EndSelectCountry && exactly:
-		err = fmt.Errorf("Invalid choice")
-		goto loop
-	case c == EndSelectCountry:
-		// We are done!
-		if !remote {
-			s.Log(c)
+	check := func() error {
+		cost := costFun(c)
+		n := nFun(append(chosen, c))
+		switch {
+		case c == EndSelectCountry && exactly:
+			return fmt.Errorf("Invalid choice")
+		case c == EndSelectCountry:
+			return nil
+		case used+cost > n:
+			return fmt.Errorf("Too much! That would use %d.", used+cost)
 		}
-		return chosen
-	case used+cost > n:
-		err = fmt.Errorf("Too much! That would use %d.", used+cost)
-		goto loop
-	default:
 		for _, check := range checks {
-			if err = check(c); err != nil {
-				goto loop
+			if err := check(c, chosen); err != nil {
+				return err
 			}
 		}
+		return nil
+	}
+	for {
+		s.Decide(&Decision{
+			Prompt: Prompt{player, "country", message},
+			thing:  &c,
+			check:  check,
+			effect: func() {
+				if c != EndSelectCountry {
+					change(s, c)
+				}
+			},
+			odds: odds,
+		})
+		if c == EndSelectCountry {
+			// We are done!
+			return chosen
+		}
+		// Success!
+		used += costFun(c)
+		chosen = append(chosen, c)
+		debugf("ops", "Added %s, now used %d\n", c.Name, used)
+		// The country is logged before applying the countryChange. This
+		// allows countryChange implementations to write to the log, which
+		// must follow country selection.
+		change(s, c)
+		s.Redraw(s.Game)
+		if used == nFun(chosen) {
+			return chosen
+		}
 	}
-	// Success!
-	used += cost
-	chosen = append(chosen, c)
-	log.Printf("Added %s, now used %d\n", c.Name, used)
-	// Must log the country before applying the countryChange. This allows
-	// countryChange implementations to write to the log, which must follow
-	// country selection.
-	if !remote {
-		s.Log(c)
-	}
-	change(s, c)
-	s.Redraw(s.Game)
-	if used == n {
-		return chosen
-	}
-	goto loop
 }
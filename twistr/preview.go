@@ -0,0 +1,120 @@
+package twistr
+
+import "strconv"
+
+// A preview plays an answer out on the live game, and then puts the game back
+// as it was. Nothing is shown, logged, sent or committed while previewing.
+type preview struct {
+	// What every die comes up.
+	roll int
+	// Whether any die was rolled.
+	rolled bool
+	// How the game was left: its value, by Evaluate, and what became of the
+	// VP, DEFCON and military operations, or who won.
+	value  float64
+	vp     int
+	defcon int
+	milOps [2]int
+	over   bool
+	victor Aff
+}
+
+// previewEnd stops a preview or a playout: the game is over, or the answer led
+// to a decision that cannot be previewed, or the playout has gone far enough.
+type previewEnd struct {
+	over   bool
+	victor Aff
+}
+
+// decide answers a decision reached while previewing. Only dice can be
+// answered.
+func (p *preview) decide(s *State, d *Decision) {
+	if _, ok := d.thing.(*int); !ok || d.Random == nil {
+		panic(previewEnd{})
+	}
+	if err := d.Check(strconv.Itoa(p.roll)); err != nil {
+		panic(previewEnd{})
+	}
+	p.rolled = true
+}
+
+// PreviewValue returns what answering d with answer is worth to player, by
+// Evaluate, averaged over the rolls of the die. The game is left as it was.
+// It returns false if the answer cannot be previewed: the decision's effect is
+// not known, or the answer leads to another decision.
+func (s *State) PreviewValue(d *Decision, answer string, player Aff) (float64, bool) {
+	if d.effect == nil || d.Check(answer) != nil {
+		return 0, false
+	}
+	return s.previewEffect(d.effect, player)
+}
+
+// previewEffect returns what effect is worth to player, averaged over the
+// rolls of the die.
+func (s *State) previewEffect(effect func(), player Aff) (float64, bool) {
+	previews, ok := s.previewRolls(effect, player)
+	if !ok {
+		return 0, false
+	}
+	total := 0.0
+	for _, p := range previews {
+		total += p.value
+	}
+	return total / float64(len(previews)), true
+}
+
+// previewRolls previews effect once for each roll of the die, or just once if
+// no die is rolled.
+func (s *State) previewRolls(effect func(), player Aff) ([]*preview, bool) {
+	previews := []*preview{}
+	for roll := 1; roll <= 6; roll++ {
+		p := &preview{roll: roll}
+		if _, ok := s.preview(p, effect, player); !ok {
+			return nil, false
+		}
+		previews = append(previews, p)
+		if !p.rolled {
+			break
+		}
+	}
+	return previews, true
+}
+
+func (s *State) preview(p *preview, effect func(), player Aff) (value float64, ok bool) {
+	dump := s.Game.Dump()
+	// The dump leaves out the transcript, what happened and turn abilities.
+	lines, happened := len(s.Transcript), len(s.Happened)
+	abilities := [2]map[Ability]bool{}
+	for _, aff := range []Aff{USA, SOV} {
+		abilities[aff] = make(map[Ability]bool)
+		for k, v := range s.TurnAbilities[aff] {
+			abilities[aff][k] = v
+		}
+	}
+	ui, mode := s.UI, s.Mode
+	s.previewing, s.UI = p, NullUI{}
+	defer func() {
+		x := recover()
+		s.previewing, s.UI, s.Mode = nil, ui, mode
+		s.Transcript = s.Transcript[:lines]
+		s.Happened = s.Happened[:happened]
+		if err := s.Game.LoadDump(dump); err != nil {
+			panic(err)
+		}
+		s.TurnAbilities = abilities
+		switch x := x.(type) {
+		case nil:
+		case previewEnd:
+			if x.over {
+				value, ok = outcomeValue(x.victor, player), true
+				p.over, p.victor = true, x.victor
+			}
+		default:
+			panic(x)
+		}
+		p.value = value
+	}()
+	effect()
+	p.vp, p.defcon, p.milOps = s.VP, s.Defcon, s.MilOps
+	return Evaluate(s.Game, player), true
+}
@@ -0,0 +1,285 @@
+package twistr
+
+import "fmt"
+import "html"
+import "io"
+import "io/ioutil"
+import "strings"
+
+// A Record is what happened in a replayed game: its transcript, and the state
+// of the game each time it asked for an input.
+type Record struct {
+	Name    string
+	Header  *AofHeader
+	Samples []Sample
+	// The game as it stood when the inputs ran out, or when it ended.
+	Game *Game
+	// Set if the game ended.
+	Over bool
+}
+
+// A Sample is the state of the game when it asked for an input.
+type Sample struct {
+	// Number of inputs handed over so far.
+	Input   int
+	Turn    int
+	AR      int
+	Phasing Aff
+	VP      int
+	Defcon  int
+	// Length of the transcript at the time.
+	Lines int
+}
+
+// endOfRecord is panicked with to stop a replay once the inputs run out.
+type endOfRecord struct{}
+
+// recorder hands a game its inputs one at a time, sampling the game as it
+// goes.
+type recorder struct {
+	inputs []string
+	pos    int
+	rec    *Record
+}
+
+func (r *recorder) sample(s *State) {
+	r.rec.Samples = append(r.rec.Samples, Sample{
+		Input:   r.pos,
+		Turn:    s.Turn,
+		AR:      s.AR,
+		Phasing: s.Phasing,
+		VP:      s.VP,
+		Defcon:  s.Defcon,
+		Lines:   len(s.Transcript),
+	})
+}
+
+func (r *recorder) Pause(s *State) {
+	r.sample(s)
+	if r.pos == len(r.inputs) {
+		panic(endOfRecord{})
+	}
+	s.History.Extend(r.inputs[r.pos])
+	r.pos++
+}
+
+func (r *recorder) End(s *State) {
+	r.sample(s)
+	r.rec.Over = true
+	panic(endOfRecord{})
+}
+
+// ReplayRecord replays inputs without a UI and records the game. If the game
+// breaks down along the way, the record so far is returned with the error.
+func ReplayRecord(inputs []string) (*Record, error) {
+	rec := &Record{Samples: []Sample{}}
+	r := &recorder{inputs: inputs, rec: rec}
+	s, err := replayHeadless(r)
+	rec.Game = s.Game
+	if err != nil {
+		return rec, fmt.Errorf("Replay failed after input %d: %s", r.pos, err.Error())
+	}
+	return rec, nil
+}
+
+// replayHeadless plays a game without a UI or a peer, with v handing it its
+// inputs, until v panics with endOfRecord. Any other panic is returned as an
+// error.
+func replayHeadless(v Viewer) (s *State, err error) {
+	s = NewState(NewHistoryBacklog(NullUI{}, nil), NewGame(), false, USA, ioutil.Discard)
+	s.LinkOut = NewCmdOut(ioutil.Discard)
+	s.Viewer = v
+	defer func() {
+		if x := recover(); x != nil {
+			switch x := x.(type) {
+			case endOfRecord:
+			case error:
+				err = x
+			default:
+				err = fmt.Errorf("%v", x)
+			}
+		}
+	}()
+	Start(s)
+	v.End(s)
+	return
+}
+
+// at returns the sample taken once the transcript had reached line.
+func (rec *Record) at(line int) Sample {
+	for _, sample := range rec.Samples {
+		if sample.Lines >= line {
+			return sample
+		}
+	}
+	return rec.Samples[len(rec.Samples)-1]
+}
+
+// recordSection is a turn, the headline or an action round.
+type recordSection struct {
+	// 1 for a turn, 2 for a phase or AR within it.
+	Level int
+	Title string
+	Lines []string
+	// The game once the section was over.
+	End Sample
+}
+
+func (rec *Record) sections() []*recordSection {
+	lines := rec.Game.Transcript
+	sections := []*recordSection{&recordSection{Level: 2, Title: "Setup"}}
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "== "):
+			sections = append(sections, &recordSection{Level: 1, Title: strings.TrimPrefix(line, "== ")})
+		case strings.HasPrefix(line, "= "):
+			title := strings.TrimSuffix(strings.TrimPrefix(line, "= "), ".")
+			sections = append(sections, &recordSection{Level: 2, Title: title})
+		default:
+			cur := sections[len(sections)-1]
+			cur.Lines = append(cur.Lines, strings.TrimSpace(line))
+		}
+		sections[len(sections)-1].End = rec.at(i + 1)
+	}
+	return sections
+}
+
+// timeline returns the last sample of each action round.
+func (rec *Record) timeline() []Sample {
+	samples := []Sample{}
+	for i, sample := range rec.Samples {
+		if i+1 < len(rec.Samples) {
+			next := rec.Samples[i+1]
+			if next.Turn == sample.Turn && next.AR == sample.AR {
+				continue
+			}
+		}
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+// lineKind sorts transcript lines for highlighting: card plays, dice rolls
+// and scoring.
+func lineKind(line string) string {
+	switch {
+	case strings.Contains(line, " plays "), strings.Contains(line, " implements "),
+		strings.Contains(line, " selects "):
+		return "play"
+	case strings.Contains(line, " rolls "), strings.HasPrefix(line, "Result:"):
+		return "roll"
+	case strings.Contains(line, " scores "), strings.Contains(line, " gains "),
+		strings.Contains(line, " loses "), strings.Contains(line, " wins "):
+		return "score"
+	default:
+		return ""
+	}
+}
+
+func (rec *Record) title() string {
+	if rec.Name == "" {
+		return "Twilight Struggle"
+	}
+	return "Twilight Struggle: " + rec.Name
+}
+
+func (rec *Record) byline() string {
+	if rec.Header == nil {
+		return ""
+	}
+	h := rec.Header
+	return fmt.Sprintf("US: %s. USSR: %s. Hosted by %s, started %s.", h.Players[USA], h.Players[SOV],
+		h.Host, h.Created.Format("2006-01-02"))
+}
+
+func (rec *Record) result() string {
+	g := rec.Game
+	return fmt.Sprintf("Turn %d AR %d. VP %d, DEFCON %d. Space race US %d, USSR %d. Military ops US %d, USSR %d.",
+		g.Turn, g.AR, g.VP, g.Defcon, g.SpaceRace[USA], g.SpaceRace[SOV], g.MilOps[USA], g.MilOps[SOV])
+}
+
+// WriteMarkdown writes the record as a Markdown document.
+func (rec *Record) WriteMarkdown(w io.Writer) error {
+	var err error
+	p := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+	p("# %s\n\n", rec.title())
+	if by := rec.byline(); by != "" {
+		p("%s\n\n", by)
+	}
+	for _, sec := range rec.sections() {
+		if sec.Level == 1 {
+			p("## %s\n\n", sec.Title)
+			continue
+		}
+		p("### %s\n\n", sec.Title)
+		for _, line := range sec.Lines {
+			switch lineKind(line) {
+			case "play":
+				p("- **%s**\n", line)
+			case "roll":
+				p("- _%s_\n", line)
+			default:
+				p("- %s\n", line)
+			}
+		}
+		if len(sec.Lines) > 0 {
+			p("\n")
+		}
+		p("VP %d, DEFCON %d.\n\n", sec.End.VP, sec.End.Defcon)
+	}
+	p("## Timeline\n\n| Turn | AR | Phasing | VP | DEFCON |\n| ---: | ---: | --- | ---: | ---: |\n")
+	for _, sample := range rec.timeline() {
+		p("| %d | %d | %s | %d | %d |\n", sample.Turn, sample.AR, sample.Phasing, sample.VP, sample.Defcon)
+	}
+	p("\n## Result\n\n%s\n", rec.result())
+	return err
+}
+
+const recordStyle = `body { font-family: sans-serif; max-width: 50em; margin: auto; }
+li.play { font-weight: bold; }
+li.roll { font-style: italic; }
+li.score { color: #805000; }
+p.state { color: #666; }
+td { text-align: right; padding: 0 1em; }`
+
+// WriteHTML writes the record as a standalone HTML page.
+func (rec *Record) WriteHTML(w io.Writer) error {
+	var err error
+	p := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+	esc := html.EscapeString
+	p("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n", esc(rec.title()))
+	p("<style>\n%s\n</style>\n</head>\n<body>\n<h1>%s</h1>\n", recordStyle, esc(rec.title()))
+	if by := rec.byline(); by != "" {
+		p("<p>%s</p>\n", esc(by))
+	}
+	for _, sec := range rec.sections() {
+		if sec.Level == 1 {
+			p("<h2>%s</h2>\n", esc(sec.Title))
+			continue
+		}
+		p("<h3>%s</h3>\n<ul>\n", esc(sec.Title))
+		for _, line := range sec.Lines {
+			if kind := lineKind(line); kind != "" {
+				p("<li class=\"%s\">%s</li>\n", kind, esc(line))
+			} else {
+				p("<li>%s</li>\n", esc(line))
+			}
+		}
+		p("</ul>\n<p class=\"state\">VP %d, DEFCON %d.</p>\n", sec.End.VP, sec.End.Defcon)
+	}
+	p("<h2>Timeline</h2>\n<table>\n<tr><th>Turn</th><th>AR</th><th>Phasing</th><th>VP</th><th>DEFCON</th></tr>\n")
+	for _, sample := range rec.timeline() {
+		p("<tr><td>%d</td><td>%d</td><td>%s</td><td>%d</td><td>%d</td></tr>\n",
+			sample.Turn, sample.AR, sample.Phasing, sample.VP, sample.Defcon)
+	}
+	p("</table>\n<h2>Result</h2>\n<p>%s</p>\n</body>\n</html>\n", esc(rec.result()))
+	return err
+}
@@ -0,0 +1,260 @@
+package twistr
+
+import "fmt"
+import "io/ioutil"
+import "strconv"
+
+// ReplayMatch plays a saved game back from its AOF, without a peer, in a
+// Viewer. Nothing is written back to the AOF.
+type ReplayMatch struct {
+	*Match
+	Saved *SavedGame
+}
+
+func NewReplayMatch(ui UI, name string) (*ReplayMatch, error) {
+	sg, err := FindGame(name)
+	if err != nil {
+		return nil, err
+	}
+	m := NewMatch(ui)
+	m.Name = name
+	m.Header = sg.Header
+	if sg.Header != nil {
+		m.Who = sg.Header.Host
+		if sg.Guest {
+			m.Who = m.Who.Opp()
+		}
+	}
+	return &ReplayMatch{Match: m, Saved: sg}, nil
+}
+
+func (r *ReplayMatch) Run() error {
+	_, inputs, err := ValidateAof(r.Saved.AofPath())
+	if err != nil {
+		return err
+	}
+	if len(inputs) == 0 {
+		return fmt.Errorf("Nothing to replay in %s", r.Name)
+	}
+	infof("Replaying %d inputs from %s\n", len(inputs), r.Saved.AofPath())
+	r.State = NewState(NewHistoryBacklog(r.UI, nil), r.Game, false, r.Who, ioutil.Discard)
+	r.State.LinkOut = NewCmdOut(ioutil.Discard)
+	// Kept in memory only, to make stepping back quick.
+	r.State.Snapshots = NewSnapshots(nil)
+	r.State.Viewer = NewStepViewer(inputs)
+	for {
+		restart, err := r.play()
+		if err != nil || !restart {
+			return err
+		}
+	}
+}
+
+// The viewer unwinds the game by panicking one of these, to replay the game
+// afresh from the top or to stop the replay.
+type (
+	viewerRestart struct{}
+	viewerQuit    struct{}
+)
+
+// play plays the game until it is over and the viewer quits, or the viewer
+// asks to start it again.
+func (r *ReplayMatch) play() (restart bool, err error) {
+	defer func() {
+		x := recover()
+		switch x.(type) {
+		case nil, viewerQuit:
+		case viewerRestart:
+			restart = true
+		default:
+			panic(x)
+		}
+	}()
+	if err = r.Match.Start(); err != nil {
+		return
+	}
+	r.State.Viewer.End(r.State)
+	return
+}
+
+// A Viewer watches a saved game being replayed, and hands the game its inputs.
+type Viewer interface {
+	// Pause is called when the game wants its next input. It returns once the
+	// input has been handed over, or else panics viewerRestart or viewerQuit.
+	Pause(s *State)
+	// End is called once the game is over. It never returns.
+	End(s *State)
+}
+
+// A StepViewer lets the user step a game through a saved game's inputs,
+// forwards and back. It hands the game inputs as the user steps forward, and
+// replays the game afresh to step back.
+type StepViewer struct {
+	inputs []string
+	// Number of inputs handed to the game so far.
+	pos int
+	// The turn and AR the game was in when it asked for each input.
+	marks map[int][2]int
+	// While stepping over many inputs, when to stop.
+	until func(s *State) bool
+	// Set once the game is over.
+	over bool
+}
+
+func NewStepViewer(inputs []string) *StepViewer {
+	return &StepViewer{
+		inputs: inputs,
+		pos:    0,
+		marks:  make(map[int][2]int),
+	}
+}
+
+const viewerHelp = "Commands: 'next' 'back' 'ar' 'back ar' 'turn' 'back turn' 'turn <n>' 'start' 'end' 'hand usa|ussr' 'log' 'board' 'deck' 'discard' 'spacerace' 'scoring' 'card <card>' 'quit'"
+
+func (v *StepViewer) Pause(s *State) {
+	v.marks[v.pos] = [2]int{s.Turn, s.AR}
+	if v.until != nil && !v.over && v.pos < len(v.inputs) && !v.until(s) {
+		v.step(s)
+		return
+	}
+	v.until = nil
+	s.Redraw(s.Game)
+	// Talk to the UI directly; History would end the replay.
+	ui := s.History.UI
+	for {
+		if v.command(s, Solicit(ui, v.status(s), nil)) {
+			return
+		}
+	}
+}
+
+// End leaves the user to step back or quit.
+func (v *StepViewer) End(s *State) {
+	v.over = true
+	for {
+		v.Pause(s)
+	}
+}
+
+func (v *StepViewer) status(s *State) string {
+	where := fmt.Sprintf("Input %d of %d, turn %d AR %d.", v.pos, len(v.inputs), s.Turn, s.AR)
+	switch {
+	case v.over:
+		return where + " Game over."
+	case v.pos == len(v.inputs):
+		return where + " End of the game record."
+	default:
+		return fmt.Sprintf("%s Next: %s", where, v.inputs[v.pos])
+	}
+}
+
+// command runs one viewer command, returning true if it handed over an input.
+func (v *StepViewer) command(s *State, reply string) bool {
+	ui := s.History.UI
+	cmd, args := parseCommand(reply)
+	switch {
+	case cmd == "" || cmd == "next" || cmd == "n":
+		return v.forward(s, nil)
+	case cmd == "ar" && len(args) == 0:
+		turn, ar := s.Turn, s.AR
+		return v.forward(s, func(s *State) bool { return s.Turn != turn || s.AR != ar })
+	case cmd == "turn" && len(args) == 0:
+		turn := s.Turn
+		return v.forward(s, func(s *State) bool { return s.Turn != turn })
+	case cmd == "turn" && len(args) == 1:
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			ui.Message(err.Error())
+			return false
+		}
+		if n > s.Turn {
+			return v.forward(s, func(s *State) bool { return s.Turn >= n })
+		}
+		for p := 0; p <= v.pos; p++ {
+			if v.marks[p][0] == n {
+				v.seek(s, p)
+			}
+		}
+		ui.Message(fmt.Sprintf("Turn %d not found.", n))
+	case cmd == "end":
+		return v.forward(s, func(s *State) bool { return false })
+	case cmd == "back" || cmd == "b":
+		if v.pos == 0 {
+			ui.Message("Already at the start.")
+			return false
+		}
+		switch {
+		case len(args) == 0:
+			v.seek(s, v.pos-1)
+		case args[0] == "ar":
+			v.seek(s, v.back(func(m [2]int) [2]int { return m }))
+		case args[0] == "turn":
+			v.seek(s, v.back(func(m [2]int) [2]int { return [2]int{m[0], 0} }))
+		}
+		ui.Message(viewerHelp)
+	case cmd == "start":
+		v.seek(s, 0)
+	case cmd == "hand" && len(args) == 1:
+		var aff Aff
+		if err := Unmarshal(args[0], &aff); err != nil {
+			ui.Message(err.Error())
+			return false
+		}
+		ShowHand(s, aff, aff, true)
+	case cmd == "discard":
+		s.Enter(NewCardMode(s.Discard.Cards))
+		s.Redraw(s.Game)
+	case cmd == "quit":
+		panic(viewerQuit{})
+	case cmd == "help", cmd == "undo", cmd == "barf", cmd == "opponent", !modal(s, reply):
+		ui.Message(viewerHelp)
+	}
+	return false
+}
+
+// forward hands over the next input, and keeps going until until holds.
+func (v *StepViewer) forward(s *State, until func(s *State) bool) bool {
+	if v.over || v.pos == len(v.inputs) {
+		s.History.UI.Message("Cannot go forward from here.")
+		return false
+	}
+	v.until = until
+	v.step(s)
+	return true
+}
+
+func (v *StepViewer) step(s *State) {
+	s.History.Extend(v.inputs[v.pos])
+	v.pos++
+}
+
+// back finds where the previous stretch of play began, where stretches are
+// the runs of inputs with the same key: the AR, or the turn.
+func (v *StepViewer) back(key func([2]int) [2]int) int {
+	p := v.pos
+	cur := key(v.marks[p])
+	for p > 0 && key(v.marks[p-1]) == cur {
+		p--
+	}
+	if p == 0 {
+		return 0
+	}
+	prev := key(v.marks[p-1])
+	for p > 0 && key(v.marks[p-1]) == prev {
+		p--
+	}
+	return p
+}
+
+// seek sets the game up to be replayed afresh up to input pos, and unwinds it
+// to ReplayMatch.Run to start it again. It never returns.
+func (v *StepViewer) seek(s *State, pos int) {
+	v.pos = pos
+	v.until = nil
+	v.over = false
+	s.History.Reset(v.inputs[:pos])
+	s.Game = NewGame()
+	s.checkpoints.n = 0
+	s.Mode = nil
+	panic(viewerRestart{})
+}
@@ -0,0 +1,255 @@
+package twistr
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// onlyChance answers decisions left to chance. Any other decision panics
+// with an asked value: a rules test gives players' answers as history.
+type onlyChance struct{}
+
+type asked struct{ d *Decision }
+
+func (onlyChance) Decide(s *State, d *Decision) string {
+	if d.Random != nil {
+		return d.Auto()
+	}
+	panic(asked{d})
+}
+
+// rulesState returns a fresh game whose players' answers are inputs.
+func rulesState(inputs ...string) *State {
+	s := NewState(NewHistoryBacklog(NullUI{}, inputs), NewGame(), true, USA, ioutil.Discard)
+	s.LinkOut = NewCmdOut(ioutil.Discard)
+	s.Agents = [2]Agent{onlyChance{}, onlyChance{}}
+	return s
+}
+
+// stopsAt runs f, returning the decision it was stopped at for want of an
+// answer, or nil if it ran to the end.
+func stopsAt(f func()) (d *Decision) {
+	defer func() {
+		if x := recover(); x != nil {
+			a, ok := x.(asked)
+			if !ok {
+				panic(x)
+			}
+			d = a.d
+		}
+	}()
+	f()
+	return nil
+}
+
+func hasChoice(d *Decision, choice string) bool {
+	for _, c := range d.Choices {
+		if c == choice {
+			return true
+		}
+	}
+	return false
+}
+
+func transcribed(s *State, text string) bool {
+	for _, line := range s.Transcript {
+		if strings.Contains(line, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func clearInfluence(s *State, player Aff) {
+	for _, c := range s.Countries {
+		c.Inf[player] = 0
+	}
+}
+
+func TestCoupOfferedOnlyWithATarget(t *testing.T) {
+	s := rulesState()
+	clearInfluence(s, USA)
+	d := stopsAt(func() { ConductOps(s, SOV, Cards[Decolonization]) })
+	if d == nil {
+		t.Fatal("ops weren't asked for")
+	}
+	if hasChoice(d, COUP.Ref()) {
+		t.Errorf("coup offered with nothing to coup: %v", d.Choices)
+	}
+	if !hasChoice(d, INFLUENCE.Ref()) {
+		t.Errorf("influence not offered: %v", d.Choices)
+	}
+
+	s = rulesState()
+	clearInfluence(s, USA)
+	s.Countries[Iran].Inf[USA] = 1
+	d = stopsAt(func() { ConductOps(s, SOV, Cards[Decolonization]) })
+	if d == nil || !hasChoice(d, COUP.Ref()) {
+		t.Errorf("coup not offered with Iran to coup")
+	}
+}
+
+func TestCoupWithNoTarget(t *testing.T) {
+	s := rulesState()
+	clearInfluence(s, USA)
+	if d := stopsAt(func() { OpCoup(s, SOV, Cards[Decolonization], false) }); d != nil {
+		t.Fatalf("asked '%s' with nothing to coup", d.Message)
+	}
+	if !transcribed(s, "has no country to coup") {
+		t.Errorf("transcript doesn't say there's nothing to coup: %v", s.Transcript)
+	}
+}
+
+func TestUNInterventionNeedsAnOpponentsEvent(t *testing.T) {
+	s := rulesState()
+	s.Hands[USA].Push(Cards[UNIntervention], Cards[DuckAndCover])
+	d := stopsAt(func() { SelectPlay(s, USA, Cards[UNIntervention]) })
+	if d == nil {
+		t.Fatal("play wasn't asked for")
+	}
+	if hasChoice(d, EVENT.Ref()) {
+		t.Errorf("event offered with no opponent's event to cancel: %v", d.Choices)
+	}
+
+	s = rulesState()
+	s.Hands[USA].Push(Cards[UNIntervention], Cards[Decolonization])
+	d = stopsAt(func() { SelectPlay(s, USA, Cards[UNIntervention]) })
+	if d == nil || !hasChoice(d, EVENT.Ref()) {
+		t.Errorf("event not offered with Decolonization in hand")
+	}
+}
+
+func TestSALTNegotiationsWithNothingToTake(t *testing.T) {
+	s := rulesState("yes")
+	s.Discard.Push(Cards[EuropeScoring])
+	if d := stopsAt(func() { PlaySALTNegotiations(s, SOV) }); d != nil {
+		t.Errorf("asked '%s' with no card to take", d.Message)
+	}
+	if len(s.Hands[SOV].Cards) != 0 {
+		t.Errorf("USSR took %v", s.Hands[SOV].Cards)
+	}
+}
+
+func TestMissileEnvyWithNothingToExchange(t *testing.T) {
+	s := rulesState()
+	if d := stopsAt(func() { PlayMissileEnvy(s, SOV) }); d != nil {
+		t.Errorf("asked '%s' with no card to exchange", d.Message)
+	}
+}
+
+func TestStarWarsWithNothingToPlay(t *testing.T) {
+	s := rulesState()
+	s.SpaceRace[USA] = 1
+	s.Discard.Push(Cards[EuropeScoring])
+	if d := stopsAt(func() { PlayStarWars(s, USA) }); d != nil {
+		t.Errorf("asked '%s' with no event to play", d.Message)
+	}
+}
+
+func TestAldrichAmesRemixWithNothingToDiscard(t *testing.T) {
+	s := rulesState()
+	if d := stopsAt(func() { PlayAldrichAmesRemix(s, SOV) }); d != nil {
+		t.Errorf("asked '%s' with no card to discard", d.Message)
+	}
+}
+
+func TestOurManInTehranWithAShortDeck(t *testing.T) {
+	s := rulesState()
+	s.Countries[Iran].Inf[USA] = s.Countries[Iran].Stability
+	s.Deck.Push(Cards[DuckAndCover], Cards[Fidel])
+	s.Discard.Push(Cards[NuclearTestBan], Cards[Decolonization], Cards[MarshallPlan])
+	d := stopsAt(func() { PlayOurManInTehran(s, USA) })
+	if d == nil {
+		t.Fatal("discards weren't asked for")
+	}
+	if len(s.Deck.Cards) != 0 || len(s.Discard.Cards) != 0 {
+		t.Errorf("drew from %d cards left in the deck and %d in the discard pile",
+			len(s.Deck.Cards), len(s.Discard.Cards))
+	}
+}
+
+func TestAskNotWithAShortDeck(t *testing.T) {
+	s := rulesState("[ duckandcover fidel ]")
+	s.Hands[USA].Push(Cards[DuckAndCover], Cards[Fidel])
+	s.Deck.Push(Cards[NuclearTestBan])
+	if d := stopsAt(func() { PlayAskNotWhatYourCountry(s, USA) }); d != nil {
+		t.Fatalf("asked '%s'", d.Message)
+	}
+	if n := len(s.Hands[USA].Cards); n != 2 {
+		t.Errorf("US drew %d cards for the 2 discarded", n)
+	}
+}
+
+// The USSR's first action takes the US's last card, so the turn ends
+// without asking the US for an action.
+func TestTurnSkipsUSWithNoCardsLeft(t *testing.T) {
+	s := rulesState("fidel", "duckandcover", "aldrichamesremix", "event", "nucleartestban")
+	s.ChinaCardFaceUp = false
+	s.Hands[SOV].Push(Cards[Fidel], Cards[AldrichAmesRemix])
+	s.Hands[USA].Push(Cards[DuckAndCover], Cards[NuclearTestBan])
+	if d := stopsAt(func() { Turn(s) }); d != nil {
+		t.Errorf("asked %s '%s' after the US ran out of cards", d.Player, d.Message)
+	}
+}
+
+// Played from the discard by Star Wars, UN Intervention may have no card
+// to go with.
+func TestUNInterventionFromStarWars(t *testing.T) {
+	s := rulesState("unintervention")
+	s.SpaceRace[USA] = 1
+	s.Discard.Push(Cards[UNIntervention])
+	s.Hands[USA].Push(Cards[DuckAndCover])
+	if d := stopsAt(func() { PlayStarWars(s, USA) }); d != nil {
+		t.Errorf("asked '%s' with no opponent's event to play", d.Message)
+	}
+	if !transcribed(s, "has no card with an opponent's event") {
+		t.Errorf("transcript doesn't say there's no card to play: %v", s.Transcript)
+	}
+}
+
+func TestSoutheastAsiaScoring(t *testing.T) {
+	s := rulesState()
+	clearInfluence(s, USA)
+	clearInfluence(s, SOV)
+	s.Countries[Burma].Inf[USA] = s.Countries[Burma].Stability
+	s.Countries[Vietnam].Inf[SOV] = s.Countries[Vietnam].Stability
+	s.Countries[Thailand].Inf[USA] = s.Countries[Thailand].Stability
+	if d := stopsAt(func() { PlaySoutheastAsiaScoring(s, SOV) }); d != nil {
+		t.Fatalf("asked '%s'", d.Message)
+	}
+	// US 1 for Burma and 2 for Thailand, USSR 1 for Vietnam.
+	if s.VP != 2 {
+		t.Errorf("VP %d after scoring, want 2", s.VP)
+	}
+}
+
+func TestShuttleDiplomacy(t *testing.T) {
+	s := rulesState()
+	clearInfluence(s, USA)
+	clearInfluence(s, SOV)
+	s.Events[ShuttleDiplomacy] = USA
+	s.Countries[Iran].Inf[SOV] = s.Countries[Iran].Stability
+	sr := ScoreRegion(s.Game, MiddleEast)
+	if sr.ShuttleDiplomacyNullified != s.Countries[Iran] {
+		t.Errorf("Shuttle Diplomacy nullified %v, want Iran", sr.ShuttleDiplomacyNullified)
+	}
+	if sr.Levels[SOV] != Nothing {
+		t.Errorf("USSR scores %s in the Middle East with its only country nullified", sr.Levels[SOV].Name())
+	}
+}
+
+func TestPlayDecisionsCarryTheCard(t *testing.T) {
+	s := rulesState()
+	d := stopsAt(func() { SelectPlay(s, SOV, Cards[NATO]) })
+	if d == nil || d.Card.Id != NATO || d.Ops != 4 {
+		t.Fatalf("Got %v, want NATO's play for 4 ops", d)
+	}
+	if got := (HeuristicBot{}).Decide(s, d); got != SPACE.Ref() {
+		t.Errorf("Got %s, want the opponent's card put into space", got)
+	}
+	d = stopsAt(func() { SelectOps(s, USA, PseudoCard(3)) })
+	if d == nil || d.Card.Id != FreeOps || d.Ops != 3 {
+		t.Errorf("Got %v, want free ops for 3", d)
+	}
+}
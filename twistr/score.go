@@ -1,5 +1,8 @@
 package twistr
 
+import "fmt"
+import "strings"
+
 type ScoreLevel int
 
 const (
@@ -122,7 +125,7 @@ func ScoreRegion(g *Game, r Region) ScoreResult {
 		}
 		counts[aff] += 1
 		if isBattleground(c) {
-			if aff == SOV && g.Effect(ShuttleDiplomacy) && (&r == &Asia || &r == &MiddleEast) && result.ShuttleDiplomacyNullified == nil {
+			if aff == SOV && g.Effect(ShuttleDiplomacy) && (r.Name == Asia.Name || r.Name == MiddleEast.Name) && result.ShuttleDiplomacyNullified == nil {
 				result.ShuttleDiplomacyNullified = c
 				counts[aff] -= 1
 			} else {
@@ -153,3 +156,118 @@ func ScoreRegion(g *Game, r Region) ScoreResult {
 	result.Levels[SOV] = score(SOV)
 	return result
 }
+
+// scoreSoutheastAsia returns what each side scores in Southeast Asia, which
+// scores country by country.
+func scoreSoutheastAsia(g *Game) [2][]Mod {
+	mods := [2][]Mod{{}, {}}
+	for _, cid := range SoutheastAsia.Countries {
+		c := g.Countries[cid]
+		points := 1
+		if cid == Thailand {
+			points = 2
+		}
+		if aff := c.Controlled(); aff != NEU {
+			mods[aff] = append(mods[aff], Mod{points, c.Name})
+		}
+	}
+	return mods
+}
+
+// scoringRegions are the regions with scoring cards, in the order shown.
+var scoringRegions = []Region{Europe, Asia, MiddleEast, Africa, CentralAmerica, SouthAmerica, SoutheastAsia}
+
+// ScoringSummary describes what each region would score if its scoring card
+// were played now, and the VP swing it would make.
+func ScoringSummary(g *Game) []string {
+	lines := []string{fmt.Sprintf("If scored now, with VP at %s:", vpString(g.VP))}
+	for _, r := range scoringRegions {
+		lines = append(lines, regionSummary(g, r)...)
+	}
+	return lines
+}
+
+func regionSummary(g *Game, r Region) []string {
+	if r.Name == SoutheastAsia.Name {
+		mods := scoreSoutheastAsia(g)
+		return []string{fmt.Sprintf("%s: US %s; USSR %s; %s", r.Name,
+			modTotal(mods[USA]), modTotal(mods[SOV]), swingString(TotalMod(mods[USA])-TotalMod(mods[SOV])))}
+	}
+	sr := ScoreRegion(g, r)
+	for _, aff := range []Aff{USA, SOV} {
+		if VPAward(sr.Levels[aff], r) == WIN {
+			return []string{fmt.Sprintf("%s: %s control; %s wins the game", r.Name, aff, aff)}
+		}
+	}
+	mods := scoreMods(sr, r)
+	lines := []string{fmt.Sprintf("%s: US %s; USSR %s; %s", r.Name,
+		levelTotal(sr.Levels[USA], mods[USA]), levelTotal(sr.Levels[SOV], mods[SOV]),
+		swingString(TotalMod(mods[USA])-TotalMod(mods[SOV])))}
+	for _, aff := range []Aff{USA, SOV} {
+		if len(sr.Battlegrounds[aff]) > 0 {
+			lines = append(lines, fmt.Sprintf("  %s battlegrounds: %s", aff, countryNames(sr.Battlegrounds[aff])))
+		}
+		if len(sr.AdjSuper[aff]) > 0 {
+			lines = append(lines, fmt.Sprintf("  %s next to the %s: %s", aff, aff.Opp(), countryNames(sr.AdjSuper[aff])))
+		}
+	}
+	if c := sr.ShuttleDiplomacyNullified; c != nil {
+		lines = append(lines, fmt.Sprintf("  %s does not count for the USSR, by Shuttle Diplomacy", c))
+	}
+	if taiwan := g.Countries[Taiwan]; taiwan.In(r) && !taiwan.Battleground && g.Effect(FormosanResolution) && taiwan.Controlled() == USA {
+		lines = append(lines, fmt.Sprintf("  %s counts as a battleground, by Formosan Resolution", taiwan))
+	}
+	return lines
+}
+
+// levelTotal describes a side's score in a region: its level, and its mods.
+func levelTotal(level ScoreLevel, mods []Mod) string {
+	if level == Nothing {
+		return "nothing"
+	}
+	return fmt.Sprintf("%s, %s", level.Name(), modTotal(mods))
+}
+
+func modTotal(mods []Mod) string {
+	nonzero := []Mod{}
+	for _, m := range mods {
+		if m.Diff != 0 {
+			nonzero = append(nonzero, m)
+		}
+	}
+	if len(nonzero) == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%d =%s", TotalMod(mods), ModSummary(nonzero))
+}
+
+// swingString describes the VP a scoring would award.
+func swingString(swing int) string {
+	switch {
+	case swing > 0:
+		return fmt.Sprintf("US +%d VP", swing)
+	case swing < 0:
+		return fmt.Sprintf("USSR +%d VP", -swing)
+	default:
+		return "no VP"
+	}
+}
+
+func vpString(vp int) string {
+	switch {
+	case vp > 0:
+		return fmt.Sprintf("US %d", vp)
+	case vp < 0:
+		return fmt.Sprintf("USSR %d", -vp)
+	default:
+		return "0"
+	}
+}
+
+func countryNames(cs []*Country) string {
+	names := make([]string, len(cs))
+	for i, c := range cs {
+		names[i] = c.Name
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,220 @@
+package twistr
+
+import "bufio"
+import "encoding/json"
+import "fmt"
+import "io"
+import "os"
+import "strings"
+
+// A Snapshot is the game as it stood at the start of a turn, so that undo and
+// resume can replay from there rather than from the first input.
+type Snapshot struct {
+	// Number of inputs played before the snapshot was taken.
+	Inputs int `json:"inputs"`
+	// Digest of those inputs; a snapshot only applies to a history that
+	// begins with the same inputs.
+	Digest string `json:"digest"`
+	// Number of commits made before the snapshot was taken.
+	Commit int `json:"commit"`
+	// The game, transcript included, as Game.MarshalJSON writes it.
+	Game json.RawMessage `json:"game"`
+}
+
+// The snapshot file is not part of the AOF's chain, so each snapshot written
+// to it is vouched for by a line in the AOF, sealed like the inputs, carrying
+// the snapshot's digest and a hash of the snapshot itself. A snapshot the AOF
+// does not vouch for is not restored from.
+const snapPrefix = controlPrefix + "SNAP "
+
+func (snap *Snapshot) hash() string {
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return ""
+	}
+	return hashDump(string(b))
+}
+
+func snapLine(snap *Snapshot) string {
+	return fmt.Sprintf("%s%s %s", snapPrefix, snap.Digest, snap.hash())
+}
+
+func withoutSnapLines(block []string) []string {
+	inputs := []string{}
+	for _, line := range block {
+		if !strings.HasPrefix(line, snapPrefix) {
+			inputs = append(inputs, line)
+		}
+	}
+	return inputs
+}
+
+// sealedSnapshots returns the snapshot hashes an AOF vouches for, by digest.
+// Only lines sealed by a chain line count; lines must already be verified.
+func sealedSnapshots(lines []string) map[string]string {
+	sealed := make(map[string]string)
+	pending := []string{}
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, snapPrefix):
+			pending = append(pending, strings.TrimPrefix(line, snapPrefix))
+		case strings.HasPrefix(line, chainPrefix):
+			for _, p := range pending {
+				if fields := strings.Fields(p); len(fields) == 2 {
+					sealed[fields[0]] = fields[1]
+				}
+			}
+			pending = []string{}
+		}
+	}
+	return sealed
+}
+
+// Snapshots holds the snapshots taken in a game, newest last, and appends new
+// ones to a snapshot file if it has one.
+type Snapshots struct {
+	list      []*Snapshot
+	w         io.Writer
+	persisted map[string]bool
+}
+
+func NewSnapshots(w io.Writer) *Snapshots {
+	return &Snapshots{
+		list:      []*Snapshot{},
+		w:         w,
+		persisted: make(map[string]bool),
+	}
+}
+
+// LoadSnapshots reads the snapshot file at path, keeping the snapshots sealed
+// vouches for, and opens it for appending new snapshots. A missing file is
+// just an empty one.
+func LoadSnapshots(path string, sealed map[string]string) (*Snapshots, error) {
+	snaps := NewSnapshots(nil)
+	if in, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			snap := &Snapshot{}
+			if err := json.Unmarshal(scanner.Bytes(), snap); err != nil {
+				warnf("Skipping bad snapshot in %s: %s\n", path, err.Error())
+				continue
+			}
+			if hash, ok := sealed[snap.Digest]; !ok || hash != snap.hash() {
+				warnf("Skipping snapshot at input %d in %s: the AOF does not vouch for it\n", snap.Inputs, path)
+				continue
+			}
+			snaps.list = append(snaps.list, snap)
+			snaps.persisted[snap.Digest] = true
+		}
+		in.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	snaps.w = out
+	return snaps, nil
+}
+
+func (ss *Snapshots) Close() error {
+	if c, ok := ss.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// SnapshotPath is where the snapshots for the AOF at aofPath are kept.
+func SnapshotPath(aofPath string) string {
+	return strings.TrimSuffix(aofPath, ".aof") + ".snap"
+}
+
+// add adds snap, and reports whether it was newly written to the snapshot
+// file.
+func (ss *Snapshots) add(snap *Snapshot) bool {
+	if n := len(ss.list); n > 0 && ss.list[n-1].Digest == snap.Digest {
+		// Already taken, e.g. the snapshot we just resumed from.
+		return false
+	}
+	ss.list = append(ss.list, snap)
+	if ss.w == nil || ss.persisted[snap.Digest] {
+		return false
+	}
+	b, err := json.Marshal(snap)
+	if err != nil {
+		errorf("Failed to encode snapshot: %s\n", err.Error())
+		return false
+	}
+	if _, err := ss.w.Write(append(b, '\n')); err != nil {
+		errorf("Failed to write snapshot: %s\n", err.Error())
+		return false
+	}
+	ss.persisted[snap.Digest] = true
+	return true
+}
+
+// latest returns the newest snapshot that applies to history, if any.
+func (ss *Snapshots) latest(history *History) *Snapshot {
+	for i := len(ss.list) - 1; i >= 0; i-- {
+		snap := ss.list[i]
+		if snap.Digest == history.Digest(snap.Inputs) {
+			return snap
+		}
+	}
+	return nil
+}
+
+// TakeSnapshot records the game as it stands now, and vouches in the AOF for
+// a snapshot written to the snapshot file.
+func (s *State) TakeSnapshot() {
+	if s.Snapshots == nil {
+		return
+	}
+	game, err := json.Marshal(s.Game)
+	if err != nil {
+		errorf("Failed to encode snapshot: %s\n", err.Error())
+		return
+	}
+	n := s.History.Index()
+	snap := &Snapshot{
+		Inputs: n,
+		Digest: s.History.Digest(n),
+		Commit: s.checkpoints.n,
+		Game:   game,
+	}
+	if !s.Snapshots.add(snap) {
+		return
+	}
+	if _, err := s.Aof.Write([]byte(snapLine(snap))); err != nil {
+		errorf("Failed to vouch for snapshot in AOF: %s\n", err.Error())
+	}
+}
+
+// RestoreSnapshot restores the game from the newest snapshot that applies to
+// the history, and skips replaying the inputs before it. It returns false if
+// there is no such snapshot.
+func (s *State) RestoreSnapshot() bool {
+	if s.Snapshots == nil {
+		return false
+	}
+	snap := s.Snapshots.latest(s.History)
+	if snap == nil {
+		return false
+	}
+	if err := json.Unmarshal(snap.Game, s.Game); err != nil {
+		warnf("Failed to restore snapshot at input %d: %s\n", snap.Inputs, err.Error())
+		s.Game = NewGame()
+		return false
+	}
+	if !s.headless {
+		infof("Restored snapshot at input %d, turn %d\n", snap.Inputs, s.Turn)
+	}
+	s.checkpoints.n = snap.Commit
+	s.History.Skip(snap.Inputs)
+	return true
+}
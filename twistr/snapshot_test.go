@@ -0,0 +1,73 @@
+package twistr
+
+import "io/ioutil"
+import "math/rand"
+import "testing"
+
+// stopAsked stops the game at the first decision it is asked.
+type stopAsked struct{}
+
+func (stopAsked) Decide(s *State, d *Decision) string {
+	panic(stopAsked{})
+}
+
+// replayTo plays inputs, restoring from the latest of snaps that applies,
+// and returns the game as it stands when the inputs run out.
+func replayTo(t *testing.T, inputs []string, snaps *Snapshots) *State {
+	history := NewHistory(NullUI{})
+	history.Reset(inputs)
+	s := NewState(history, NewGame(), true, USA, ioutil.Discard)
+	s.LinkOut = NewCmdOut(ioutil.Discard)
+	s.Snapshots = snaps
+	s.headless = true
+	s.Agents = [2]Agent{stopAsked{}, stopAsked{}}
+	func() {
+		defer func() {
+			switch x := recover().(type) {
+			case nil, stopAsked, previewEnd:
+			default:
+				t.Fatalf("Replay failed in turn %d: %v", s.Turn, x)
+			}
+		}()
+		Start(s)
+	}()
+	return s
+}
+
+func TestRestoreSnapshotEqualsReplay(t *testing.T) {
+	h := &Headless{
+		Agents: [2]Agent{HeuristicBot{}, NewRandomBot(2)},
+		Dice:   rand.New(rand.NewSource(2)),
+	}
+	played, err := h.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	taken := NewSnapshots(nil)
+	replayTo(t, played.Inputs, taken)
+	if len(taken.list) < 3 {
+		t.Fatalf("Only %d snapshots taken", len(taken.list))
+	}
+	for _, snap := range taken.list[1:] {
+		inputs := played.Inputs[:snap.Inputs]
+		replayed := replayTo(t, inputs, nil)
+		snaps := NewSnapshots(nil)
+		snaps.add(snap)
+		restored := replayTo(t, inputs, snaps)
+		if restored.Turn < 2 {
+			t.Fatalf("Restored to turn %d, want a later turn boundary", restored.Turn)
+		}
+		if restored.Game.Dump() != replayed.Game.Dump() {
+			t.Errorf("Restored at input %d:\n%s\nwant:\n%s", snap.Inputs, restored.Game.Dump(), replayed.Game.Dump())
+		}
+		if restored.checkpoints.n != replayed.checkpoints.n {
+			t.Errorf("Restored at commit %d, want %d", restored.checkpoints.n, replayed.checkpoints.n)
+		}
+	}
+	// Restored from the latest, the rest of the game plays out the same.
+	snaps := NewSnapshots(nil)
+	snaps.add(taken.list[len(taken.list)-1])
+	if s := replayTo(t, played.Inputs, snaps); s.Game.Dump() != played.Game.Dump() {
+		t.Errorf("Game restored and played out:\n%s\nwant:\n%s", s.Game.Dump(), played.Game.Dump())
+	}
+}
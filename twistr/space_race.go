@@ -29,6 +29,34 @@ func (s SpaceId) String() string {
 	}
 }
 
+func (s SpaceId) Ref() string {
+	switch s {
+	case NoAbility:
+		// Entering a box with no ability still marks it in SREvents.
+		return "noability"
+	case TwoSpace:
+		return "twospace"
+	case OppHeadlineFirst:
+		return "oppheadlinefirst"
+	case DiscardHeld:
+		return "discardheld"
+	case ExtraAR:
+		return "extraar"
+	default:
+		return "?"
+	}
+}
+
+// lookupSpaceId expects the incoming string to be lowercase.
+func lookupSpaceId(name string) (SpaceId, error) {
+	for _, s := range []SpaceId{NoAbility, TwoSpace, OppHeadlineFirst, DiscardHeld, ExtraAR} {
+		if s.Ref() == name {
+			return s, nil
+		}
+	}
+	return NoAbility, fmt.Errorf("Bad space race ability '%s'", name)
+}
+
 type SRBox struct {
 	MaxRoll    int
 	OpsNeeded  int
@@ -3,6 +3,8 @@ package twistr
 import "fmt"
 import "io"
 import "log"
+import "math/rand"
+import "time"
 
 type State struct {
 	UI
@@ -14,24 +16,74 @@ type State struct {
 	LinkIn      *CmdIn
 	LinkOut     *CmdOut
 	Aof         io.Writer
+	// Where to write both peers' game states if they desync.
+	DesyncPath string
+	// Snapshots taken at the start of each turn, if any are kept.
+	Snapshots *Snapshots
+	// Set when replaying a saved game; the viewer is asked for each input
+	// instead of the players.
+	Viewer Viewer
+	// Who answers each side's decisions, if not the local player through the
+	// UI and the other side over the link.
+	Agents [2]Agent
+	// The decision the game is waiting on.
+	Asking *Decision
+	// Where the dice, shuffles and random discards come from. Each game has
+	// its own, made when first needed if not set.
+	dice *rand.Rand
+	// Set while previewing an answer; see State.PreviewValue.
+	previewing *preview
+	// Set for games played with no one watching, like a bot's playouts and
+	// tournament games: nothing is committed, and Finish stops the game.
+	headless    bool
+	checkpoints *checkpoints
+}
+
+// random returns where the game's random decisions come from.
+func (s *State) random() *rand.Rand {
+	if s.dice == nil {
+		s.dice = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return s.dice
 }
 
 // Checkpoint game. User cannot undo past the point this is called.
+// Both peers commit at the same points in the game, so each commit is also
+// where the peers verify that their games agree.
 func (s *State) Commit() {
+	if s.previewing != nil || s.headless {
+		return
+	}
+	k := s.checkpoint()
 	if s.History.InReplay() {
-		log.Println("Not committing, in replay")
+		debugf("replay", "Not committing, in replay")
+		return
+	}
+	s.flush()
+	if s.LinkIn == nil {
 		return
 	}
-	log.Println("Committing...")
+	s.LinkOut.Send(fmt.Sprintf("%sHASH %d %s", controlPrefix, k, hashDump(s.checkpoints.local[k])))
+	s.readControl()
+	s.verify(k)
+}
+
+// flush sends buffered inputs to the peer and writes them to the AOF.
+func (s *State) flush() {
+	if s.History.InReplay() {
+		debugf("replay", "Not committing, in replay")
+		return
+	}
+	debugf("commit", "Committing...")
 	s.LinkOut.Commit()
 	buffered := s.History.Commit()
 	if len(buffered) > 0 {
-		log.Println("Writing buffered to aof")
+		debugf("commit", "Writing buffered to aof")
 		if _, err := s.Aof.Write(append([]byte(buffered), '\n')); err != nil {
 			log.Fatalf("Failed to flush to aof: %s\n", err.Error())
 		}
 	} else {
-		log.Println("nothing buffered to aof")
+		debugf("commit", "nothing buffered to aof")
 	}
 	s.Redraw(s.Game)
 }
@@ -42,20 +94,20 @@ func (s *State) CanUndo() bool {
 
 func (s *State) Log(thing interface{}) (err error) {
 	if s.History.InReplay() || s.History.Replaying {
-		log.Printf("Not logging, replay %v replaying %v\n", s.History.InReplay(), s.History.Replaying)
+		debugf("replay", "Not logging, replay %v replaying %v\n", s.History.InReplay(), s.History.Replaying)
 		return nil
 	}
 	var b []byte
 	if b, err = Marshal(thing); err != nil {
-		log.Println(err)
+		errorf("%s", err)
 		return
 	}
-	log.Printf("Logging %s to history+linkout\n", string(b))
+	debugf("input", "Logging %s to history+linkout\n", string(b))
 	if _, err = s.History.Write(b); err != nil {
-		log.Println(err)
+		errorf("%s", err)
 	}
 	if _, err = s.LinkOut.Write(append(b, '\n')); err != nil {
-		log.Println(err)
+		errorf("%s", err)
 	}
 	return
 }
@@ -64,38 +116,90 @@ func (s *State) WaitRemote() string {
 	// Reset to board view to prevent showing secrets to opponent
 	//s.Enter(nil)
 	//s.Redraw(s.Game)
-	line, ok := <-s.LinkIn.Inputs
-	if !ok {
-		log.Fatalf("LinkIn is done!")
+	for {
+		select {
+		case line, ok := <-s.LinkIn.Inputs:
+			if !ok {
+				log.Fatalf("LinkIn is done!")
+			}
+			return line
+		case line := <-s.LinkIn.Control:
+			s.control(line)
+		}
+	}
+}
+
+// inputCheck validates an input after it has been parsed. Checks close over
+// the value being read into, and return an error if it breaks the rules.
+type inputCheck func() error
+
+// checkInput reads line into thing, looking up the countries it names among
+// countries, or among the countries as they start if nil, and checks it.
+func checkInput(line string, thing interface{}, check inputCheck, countries map[CountryId]*Country) error {
+	if countries == nil {
+		countries = Countries
 	}
-	return line
+	if err := unmarshalIn(line, thing, countries); err != nil {
+		return err
+	}
+	if check != nil {
+		return check()
+	}
+	return nil
+}
+
+// An InvalidHistory is a line of history that doesn't answer the decision it
+// is read for. The game can't be replayed past it, so loading the game fails.
+type InvalidHistory struct {
+	// Line of history, counting from 1.
+	Line  int
+	Input string
+	Err   error
 }
 
-func (s *State) ReadInto(thing interface{}, fromRemote bool) bool {
-	var ok bool
-	var line string
-	ok, line = s.History.Next()
-	if !ok {
-		if !fromRemote {
+func (e *InvalidHistory) Error() string {
+	return fmt.Sprintf("Invalid input '%s' on line %d of history: %s", e.Input, e.Line, e.Err.Error())
+}
+
+// ReadInto reads the answer to a decision from history, if history has one.
+// An invalid line in history panics with an *InvalidHistory.
+func (s *State) ReadInto(d *Decision) bool {
+	for {
+		ok, line := s.History.Next()
+		if !ok && s.Viewer != nil {
+			s.Viewer.Pause(s)
+			continue
+		}
+		if !ok {
 			return false
 		}
-		// Autocommit to preclude deadlock
-		s.Commit()
-		line = s.WaitRemote()
-		// XXX: do we need to flush aof at this point?
-		log.Printf("Read %s in from remote. Writing to history\n", line)
-		if _, err := s.History.Write([]byte(line)); err != nil {
-			log.Println(err)
+		debugf("replay", "Read %s in from history\n", line)
+		if err := d.Check(line); err != nil {
+			panic(&InvalidHistory{Line: s.History.index, Input: line, Err: err})
 		}
-	} else {
-		log.Printf("Read %s in from history\n", line)
+		return true
 	}
+}
 
-	if err := Unmarshal(line, thing); err != nil {
-		log.Printf("Corrupt log! Tried to parse '%s' into %s\n", line, thing)
-		return false
-	}
-	return true
+// RemoteViolation is called when the peer sends an input that breaks the
+// rules. Either the games have desynced or the peer is cheating; in both cases
+// the input must not be applied, so the game halts.
+func (s *State) RemoteViolation(line string, err error) {
+	msg := fmt.Sprintf("Desync or cheat detected! Peer sent '%s': %s", line, err.Error())
+	errorf("%s", msg)
+	s.Halt(msg)
+}
+
+// halted stops a game that cannot go on; Match.Start recovers it.
+type halted struct {
+	msg string
+}
+
+// Halt stops the game for good, leaving msg on screen. Nothing more is
+// written to the AOF or sent to the peer.
+func (s *State) Halt(msg string) {
+	s.UI.Message(msg + " Game halted.")
+	panic(halted{msg})
 }
 
 func (s *State) Undo() {
@@ -103,6 +207,8 @@ func (s *State) Undo() {
 	s.LinkOut.Pop()
 	// Totally reset all state, and replay history.
 	s.Game = NewGame()
+	s.checkpoints.n = 0
+	// Start replays from the latest snapshot before the undone input.
 	Start(s)
 }
 
@@ -146,6 +252,7 @@ func NewState(history *History, game *Game, isServer bool, localPlayer Aff, aof
 		Server:      isServer,
 		LocalPlayer: localPlayer,
 		Aof:         aof,
+		checkpoints: newCheckpoints(),
 	}
 }
 
@@ -245,14 +352,18 @@ func (s *State) CancelAbility(which Ability, player Aff) {
 }
 
 type Game struct {
-	Transcript      []string
-	VP              int
-	Defcon          int
-	MilOps          [2]int
-	SpaceRace       [2]int
-	Turn            int
-	AR              int
-	Phasing         Aff
+	Transcript []string
+	// What happened worth counting, in order; see GameEvent.
+	Happened  []GameEvent
+	VP        int
+	Defcon    int
+	MilOps    [2]int
+	SpaceRace [2]int
+	Turn      int
+	AR        int
+	Phasing   Aff
+	// The game's own countries, apart from every other game's; see
+	// lookupCountryIn.
 	Countries       map[CountryId]*Country
 	Events          map[CardId]Aff
 	TurnEvents      map[CardId]Aff
@@ -269,9 +380,9 @@ type Game struct {
 }
 
 func NewGame() *Game {
-	resetCountries()
 	return &Game{
 		Transcript:      []string{},
+		Happened:        []GameEvent{},
 		VP:              0,
 		Defcon:          5,
 		MilOps:          [2]int{0, 0},
@@ -279,7 +390,7 @@ func NewGame() *Game {
 		Turn:            1,
 		AR:              1,
 		Phasing:         SOV,
-		Countries:       Countries,
+		Countries:       newCountries(),
 		Events:          make(map[CardId]Aff),
 		TurnEvents:      make(map[CardId]Aff),
 		TurnAbilities:   [2]map[Ability]bool{make(map[Ability]bool), make(map[Ability]bool)},
@@ -0,0 +1,50 @@
+package twistr
+
+import "path/filepath"
+import "strings"
+import "testing"
+
+func TestReadIntoInvalidHistory(t *testing.T) {
+	s := rulesState("ops", "bogus")
+	card := Cards[DuckAndCover]
+	var ih *InvalidHistory
+	func() {
+		defer func() {
+			ih, _ = recover().(*InvalidHistory)
+		}()
+		SelectPlay(s, USA, card)
+		SelectPlay(s, USA, card)
+	}()
+	if ih == nil {
+		t.Fatal("Read an invalid line of history")
+	}
+	if ih.Line != 2 || ih.Input != "bogus" {
+		t.Errorf("Got line %d '%s', want line 2 'bogus'", ih.Line, ih.Input)
+	}
+}
+
+func TestNotateInvalidHistory(t *testing.T) {
+	// The first decision is the shuffle of the early war cards.
+	_, err := Notate([]string{"bogus"})
+	if err == nil || !strings.Contains(err.Error(), "line 1 of history") {
+		t.Errorf("Got %v, want an error at line 1 of history", err)
+	}
+}
+
+func TestMatchInvalidHistory(t *testing.T) {
+	defer func(dir string) { DataDir = dir }(DataDir)
+	if err := SetDataDir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	header := NewAofHeader(USA, [2]string{"alice", "random-bot"}, RNGLive)
+	path := filepath.Join(DataDir, "g.aof")
+	if _, err := writeAof(path, header, []byte("secret"), []string{"bogus"}); err != nil {
+		t.Fatal(err)
+	}
+	m := NewBotMatch(NullUI{}, "g", USA, "random", NewRandomBot(1))
+	defer m.Close()
+	err := m.Run()
+	if ih, ok := err.(*InvalidHistory); !ok || ih.Line != 1 {
+		t.Errorf("Got %v, want an invalid line 1 of history", err)
+	}
+}
@@ -0,0 +1,319 @@
+package twistr
+
+import "crypto/sha256"
+import "encoding/hex"
+import "encoding/json"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "sort"
+import "strings"
+import "text/tabwriter"
+
+// GameStats is what the statistics keep of one finished game.
+type GameStats struct {
+	Name string `json:"name"`
+	// Hash of the game's inputs, to tell when it needs reading again.
+	Digest  string    `json:"digest"`
+	Players [2]string `json:"players"`
+	// NEU for a draw.
+	Winner    Aff         `json:"winner"`
+	Reason    string      `json:"reason"`
+	VP        int         `json:"vp"`
+	Turn      int         `json:"turn"`
+	Plays     []CardPlay  `json:"plays"`
+	Headlines []CardPlay  `json:"headlines"`
+	Coups     []CoupStats `json:"coups"`
+}
+
+// A CardPlay is a card played in an action round or headlined.
+type CardPlay struct {
+	Card   string `json:"card"`
+	Player Aff    `json:"player"`
+	// "event", "ops" or "space".
+	Kind string `json:"kind"`
+}
+
+type CoupStats struct {
+	Player  Aff    `json:"player"`
+	Country string `json:"country"`
+	Success bool   `json:"success"`
+}
+
+// Stats holds the statistics of every finished game in DataDir, kept in
+// stats.json there.
+type Stats struct {
+	Games map[string]*GameStats `json:"games"`
+	// Digests of the games not finished yet, so they are not read again
+	// until they change.
+	Unfinished map[string]string `json:"unfinished"`
+}
+
+func statsPath() string {
+	return filepath.Join(DataDir, "stats.json")
+}
+
+// LoadStats reads the statistics kept so far; there may be none yet.
+func LoadStats() (*Stats, error) {
+	st := &Stats{Games: make(map[string]*GameStats), Unfinished: make(map[string]string)}
+	b, err := ioutil.ReadFile(statsPath())
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, fmt.Errorf("Bad statistics in %s: %s", statsPath(), err.Error())
+	}
+	return st, nil
+}
+
+func (st *Stats) Save() error {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(statsPath(), b, 0666)
+}
+
+// Update reads every saved game that is new or changed since the last update,
+// keeping those that are finished. A game kept both as hosted and as a guest
+// copy, under different names, counts once, as hosted. It returns how many
+// games it read.
+func (st *Stats) Update() (int, error) {
+	games, err := ListGames()
+	if err != nil {
+		return 0, err
+	}
+	sort.SliceStable(games, func(i, j int) bool {
+		return !games[i].Guest && games[j].Guest
+	})
+	seen := make(map[string]bool)
+	// The headers of the games counted; copies of a game share its header.
+	counted := make(map[string]bool)
+	read := 0
+	for _, sg := range games {
+		if sg.Header != nil {
+			header := strings.Join(sg.Header.Lines(), "\n")
+			if counted[header] {
+				continue
+			}
+			counted[header] = true
+		}
+		seen[sg.Name] = true
+		_, inputs, err := ValidateAof(sg.AofPath())
+		if err != nil {
+			warnf("Leaving %s out of the statistics: %s\n", sg.Name, err.Error())
+			delete(st.Games, sg.Name)
+			continue
+		}
+		sum := sha256.Sum256([]byte(strings.Join(inputs, "\n")))
+		digest := hex.EncodeToString(sum[:])
+		if gs, ok := st.Games[sg.Name]; ok && gs.Digest == digest || st.Unfinished[sg.Name] == digest {
+			continue
+		}
+		delete(st.Games, sg.Name)
+		delete(st.Unfinished, sg.Name)
+		read++
+		rec, err := ReplayRecord(inputs)
+		if err != nil {
+			warnf("Leaving %s out of the statistics: %s\n", sg.Name, err.Error())
+			continue
+		}
+		if !rec.Over {
+			st.Unfinished[sg.Name] = digest
+			continue
+		}
+		gs := gameStats(rec.Game)
+		gs.Name = sg.Name
+		gs.Digest = digest
+		if sg.Header != nil {
+			gs.Players = sg.Header.Players
+		}
+		st.Games[sg.Name] = gs
+	}
+	for name := range st.Games {
+		if !seen[name] {
+			delete(st.Games, name)
+		}
+	}
+	for name := range st.Unfinished {
+		if !seen[name] {
+			delete(st.Unfinished, name)
+		}
+	}
+	return read, nil
+}
+
+// A GameEvent is something that happened in the game that the statistics
+// count: a card headlined or played, a coup, or the game being won.
+type GameEvent struct {
+	Turn   int
+	AR     int
+	Player Aff
+	// "headline", "event", "ops", "space", "coup" or "win".
+	Kind string
+	// The card played or the country coupped, by ref, or why the game was
+	// won.
+	What string
+	// Whether a coup removed any influence.
+	Success bool
+}
+
+// happen records that e happened, now.
+func (s *State) happen(e GameEvent) {
+	e.Turn, e.AR = s.Turn, s.AR
+	s.Happened = append(s.Happened, e)
+}
+
+// gameStats reads the statistics of a finished game from what happened in
+// it. A game not won outright is decided by the final VP.
+func gameStats(g *Game) *GameStats {
+	gs := &GameStats{
+		Winner:    NEU,
+		Reason:    "final VP",
+		VP:        g.VP,
+		Turn:      g.Turn,
+		Plays:     []CardPlay{},
+		Headlines: []CardPlay{},
+		Coups:     []CoupStats{},
+	}
+	switch {
+	case g.VP > 0:
+		gs.Winner = USA
+	case g.VP < 0:
+		gs.Winner = SOV
+	}
+	for _, e := range g.Happened {
+		switch e.Kind {
+		case "win":
+			gs.Winner, gs.Reason = e.Player, e.What
+		case "headline":
+			gs.Headlines = append(gs.Headlines, CardPlay{e.What, e.Player, EVENT.Ref()})
+		case "coup":
+			gs.Coups = append(gs.Coups, CoupStats{e.Player, e.What, e.Success})
+		default:
+			gs.Plays = append(gs.Plays, CardPlay{e.What, e.Player, e.Kind})
+		}
+	}
+	return gs
+}
+
+// rate formats n out of total as a percentage.
+func rate(n, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.0f%%", 100*float64(n)/float64(total))
+}
+
+// cardUsage is how often a card was played each way, and how often the side
+// playing it won.
+type cardUsage struct {
+	card  string
+	kinds map[string]int
+	plays int
+	wins  int
+}
+
+// WriteReport writes a summary of the statistics.
+func (st *Stats) WriteReport(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	p := func(format string, args ...interface{}) {
+		fmt.Fprintf(tw, format, args...)
+	}
+	n := len(st.Games)
+	wins := map[Aff]int{}
+	reasons := map[string]int{}
+	totalVP := 0
+	usage := map[string]*cardUsage{}
+	headlines := [2]map[string]int{{}, {}}
+	coups := [2][2]int{}
+	for _, gs := range st.Games {
+		wins[gs.Winner]++
+		reasons[gs.Reason]++
+		totalVP += gs.VP
+		for _, play := range gs.Plays {
+			u, ok := usage[play.Card]
+			if !ok {
+				u = &cardUsage{card: play.Card, kinds: map[string]int{}}
+				usage[play.Card] = u
+			}
+			u.kinds[play.Kind]++
+			u.plays++
+			if play.Player == gs.Winner {
+				u.wins++
+			}
+		}
+		for _, hl := range gs.Headlines {
+			if hl.Player == USA || hl.Player == SOV {
+				headlines[hl.Player][hl.Card]++
+			}
+		}
+		for _, c := range gs.Coups {
+			if c.Player != USA && c.Player != SOV {
+				continue
+			}
+			coups[c.Player][0]++
+			if c.Success {
+				coups[c.Player][1]++
+			}
+		}
+	}
+	p("Finished games\t%d\n", n)
+	if n == 0 {
+		return tw.Flush()
+	}
+	p("US wins\t%d\t%s\n", wins[USA], rate(wins[USA], n))
+	p("USSR wins\t%d\t%s\n", wins[SOV], rate(wins[SOV], n))
+	p("Draws\t%d\t%s\n", wins[NEU], rate(wins[NEU], n))
+	p("Average final VP\t%.1f\n", float64(totalVP)/float64(n))
+	p("\nEnded by\tGames\n")
+	for _, reason := range sortedKeys(reasons) {
+		p("%s\t%d\n", reason, reasons[reason])
+	}
+	p("\nCoups\tTried\tSucceeded\n")
+	for _, aff := range []Aff{USA, SOV} {
+		p("%s\t%d\t%s\n", aff, coups[aff][0], rate(coups[aff][1], coups[aff][0]))
+	}
+	for _, aff := range []Aff{USA, SOV} {
+		p("\n%s headlines\tTimes\n", aff)
+		for _, card := range sortedKeys(headlines[aff]) {
+			p("%s\t%d\n", card, headlines[aff][card])
+		}
+	}
+	cards := []*cardUsage{}
+	for _, u := range usage {
+		cards = append(cards, u)
+	}
+	sort.Slice(cards, func(i, j int) bool {
+		if cards[i].plays != cards[j].plays {
+			return cards[i].plays > cards[j].plays
+		}
+		return cards[i].card < cards[j].card
+	})
+	p("\nCard\tPlays\tEvent\tOps\tSpace\tPlayer won\n")
+	for _, u := range cards {
+		p("%s\t%d\t%d\t%d\t%d\t%s\n", u.card, u.plays, u.kinds[EVENT.Ref()], u.kinds[OPS.Ref()],
+			u.kinds[SPACE.Ref()], rate(u.wins, u.plays))
+	}
+	return tw.Flush()
+}
+
+// sortedKeys returns the keys of counts, most counted first.
+func sortedKeys(counts map[string]int) []string {
+	keys := []string{}
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
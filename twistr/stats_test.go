@@ -0,0 +1,134 @@
+package twistr
+
+import "bytes"
+import "math/rand"
+import "os"
+import "path/filepath"
+import "strings"
+import "testing"
+import "time"
+
+func statsHeader(created time.Time) *AofHeader {
+	header := NewAofHeader(USA, [2]string{"alice", "bob"}, RNGLive)
+	header.Created = created
+	return header
+}
+
+func TestStatsUpdate(t *testing.T) {
+	tempDataDir(t)
+	h := &Headless{
+		Agents: [2]Agent{HeuristicBot{}, NewRandomBot(1)},
+		Dice:   rand.New(rand.NewSource(1)),
+	}
+	played, err := h.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	// The same game, hosted and as a guest copy.
+	saveGameAs(t, "g", false, statsHeader(day), played.Inputs)
+	saveGameAs(t, "copy", true, statsHeader(day), played.Inputs)
+	saveGameAs(t, "u", false, statsHeader(day.Add(time.Hour)), played.Inputs[:40])
+
+	st, err := LoadStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := st.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read != 2 {
+		t.Errorf("Read %d games, want 2", read)
+	}
+	if len(st.Games) != 1 || st.Games["g"] == nil {
+		t.Fatalf("Got games %v, want just g", st.Games)
+	}
+	if gs := st.Games["g"]; gs.Winner != played.Winner || gs.Players[USA] != "alice" || len(gs.Plays) == 0 {
+		t.Errorf("Got %+v, won by %s", gs, played.Winner)
+	}
+	if _, ok := st.Unfinished["u"]; !ok || len(st.Unfinished) != 1 {
+		t.Errorf("Got unfinished %v, want just u", st.Unfinished)
+	}
+	if err := st.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing changed, so nothing is read again.
+	if st, err = LoadStats(); err != nil {
+		t.Fatal(err)
+	}
+	if read, err = st.Update(); err != nil || read != 0 {
+		t.Errorf("Read %d games again, %v", read, err)
+	}
+
+	// With the hosted game gone, the guest copy counts.
+	if err := os.Remove(filepath.Join(DataDir, "g.aof")); err != nil {
+		t.Fatal(err)
+	}
+	if read, err = st.Update(); err != nil || read != 1 {
+		t.Errorf("Read %d games, %v; want the guest copy", read, err)
+	}
+	if len(st.Games) != 1 || st.Games["copy"] == nil {
+		t.Errorf("Got games %v, want just the guest copy", st.Games)
+	}
+}
+
+// reportLine returns the fields of the report's line starting with label.
+func reportLine(report, label string) []string {
+	for _, line := range strings.Split(report, "\n") {
+		if strings.HasPrefix(line, label+" ") {
+			return strings.Fields(strings.TrimPrefix(line, label))
+		}
+	}
+	return nil
+}
+
+func TestWriteReport(t *testing.T) {
+	st := &Stats{Games: map[string]*GameStats{
+		"a": &GameStats{
+			Winner: USA, Reason: "final VP", VP: 4,
+			Plays:     []CardPlay{{"nato", USA, "event"}, {"fidel", USA, "space"}},
+			Headlines: []CardPlay{{"duckandcover", USA, "event"}},
+			Coups:     []CoupStats{{SOV, "iran", true}, {SOV, "iraq", false}},
+		},
+		"b": &GameStats{
+			Winner: SOV, Reason: "europe", VP: -20,
+			Plays: []CardPlay{{"nato", USA, "ops"}},
+		},
+	}}
+	b := new(bytes.Buffer)
+	if err := st.WriteReport(b); err != nil {
+		t.Fatal(err)
+	}
+	report := b.String()
+	for _, want := range []struct {
+		label  string
+		fields string
+	}{
+		{"Finished games", "2"},
+		{"US wins", "1 50%"},
+		{"USSR wins", "1 50%"},
+		{"Draws", "0 0%"},
+		{"Average final VP", "-8.0"},
+		{"europe", "1"},
+		{"nato", "2 1 1 0 50%"},
+		{"fidel", "1 0 0 1 100%"},
+		{"duckandcover", "1"},
+	} {
+		if got := strings.Join(reportLine(report, want.label), " "); got != want.fields {
+			t.Errorf("Got '%s' for %s, want '%s'", got, want.label, want.fields)
+		}
+	}
+	if !strings.Contains(report, "\nUSSR   2      50%\n") {
+		t.Errorf("USSR coups missing from:\n%s", report)
+	}
+
+	b.Reset()
+	if err := (&Stats{}).WriteReport(b); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(b.String()); strings.Join(got, " ") != "Finished games 0" {
+		t.Errorf("Got '%s' with no games", b.String())
+	}
+}
@@ -2,9 +2,12 @@ package twistr
 
 import "bufio"
 import "fmt"
+import "io"
 import "os"
 import "strings"
 
+// TerminalUI is a plain line-based UI, for terminals where ncurses is not
+// wanted.
 type TerminalUI struct {
 	in *bufio.Reader
 }
@@ -14,7 +17,12 @@ func MakeTerminalUI() *TerminalUI {
 }
 
 func (t *TerminalUI) Input() (string, error) {
+	fmt.Fprint(os.Stdout, "> ")
 	text, err := t.in.ReadString('\n')
+	if err == io.EOF && text != "" {
+		// The last line, unterminated; the next call returns io.EOF.
+		err = nil
+	}
 	if err != nil {
 		return "", err
 	}
@@ -26,6 +34,29 @@ func (t *TerminalUI) Message(message string) error {
 	return err
 }
 
-func (t *TerminalUI) Redraw(s *State) error {
+func (t *TerminalUI) ShowMessages(messages []string) {
+	for _, m := range messages {
+		t.Message(m)
+	}
+}
+
+func (t *TerminalUI) ShowCards(cards []Card) {
+	names := make([]string, len(cards))
+	for i, c := range cards {
+		names[i] = c.Name
+	}
+	t.Message(strings.Join(names, ", "))
+}
+
+func (t *TerminalUI) ShowSpaceRace(positions [2]int) {
+	t.Message(fmt.Sprintf("Space race: US %d, USSR %d", positions[USA], positions[SOV]))
+}
+
+func (t *TerminalUI) Redraw(g *Game) {
+	t.Message(fmt.Sprintf("Turn %d AR %d, %s phasing. VP %d, DEFCON %d.",
+		g.Turn, g.AR, g.Phasing, g.VP, g.Defcon))
+}
+
+func (t *TerminalUI) Close() error {
 	return nil
 }
@@ -0,0 +1,217 @@
+package twistr
+
+import "fmt"
+import "io"
+import "math"
+import "math/rand"
+import "text/tabwriter"
+
+// A Tournament pits two kinds of bot against each other, in games played
+// without a UI, a link or an AOF. The bots swap sides every game.
+type Tournament struct {
+	// The kinds of bot; see NewBot.
+	Kinds [2]string
+	Games int
+	// Game n is played from seed Seed+n: its dice, its shuffles and its
+	// bots' seeds all come from there, so a game can be played again.
+	Seed int64
+	// How many games to play at once.
+	Parallel int
+	Budget   Budget
+}
+
+// A TournamentGame is how one game of a tournament went.
+type TournamentGame struct {
+	N    int
+	Seed int64
+	// The kind of bot playing each side.
+	Sides [2]string
+	// NEU for a draw.
+	Winner Aff
+	Reason string
+	VP     int
+	// The turn the game ended in.
+	Turn int
+	// Set if the game could not be finished.
+	Err error
+}
+
+// Run plays the tournament, calling done with each game as it finishes. It
+// returns the games in order.
+func (t *Tournament) Run(done func(TournamentGame)) ([]TournamentGame, error) {
+	for _, kind := range t.Kinds {
+		if _, err := NewBot(kind, 0, t.Budget); err != nil {
+			return nil, err
+		}
+	}
+	parallel := Max(t.Parallel, 1)
+	todo := make(chan int)
+	results := make(chan TournamentGame)
+	for i := 0; i < parallel; i++ {
+		go func() {
+			for n := range todo {
+				results <- t.play(n)
+			}
+		}()
+	}
+	go func() {
+		for n := 0; n < t.Games; n++ {
+			todo <- n
+		}
+		close(todo)
+	}()
+	games := make([]TournamentGame, t.Games)
+	for i := 0; i < t.Games; i++ {
+		tg := <-results
+		games[tg.N] = tg
+		if done != nil {
+			done(tg)
+		}
+	}
+	return games, nil
+}
+
+// play plays game n of the tournament.
+func (t *Tournament) play(n int) TournamentGame {
+	tg := TournamentGame{N: n, Seed: t.Seed + int64(n), Winner: NEU}
+	first := USA
+	if n%2 == 1 {
+		first = SOV
+	}
+	tg.Sides[first], tg.Sides[first.Opp()] = t.Kinds[0], t.Kinds[1]
+	dice := rand.New(rand.NewSource(tg.Seed))
+	h := &Headless{Dice: dice}
+	for _, aff := range []Aff{USA, SOV} {
+		h.Agents[aff], _ = NewBot(tg.Sides[aff], dice.Int63(), t.Budget)
+	}
+	result, err := h.Run()
+	if err != nil {
+		tg.Err = err
+		return tg
+	}
+	tg.Winner, tg.Reason, tg.VP = result.Winner, result.Reason, result.Game.VP
+	// A game played out leaves Turn at 11, one past the last turn played;
+	// see playTurns.
+	tg.Turn = Min(result.Game.Turn, 10)
+	return tg
+}
+
+// String describes how the game went.
+func (tg TournamentGame) String() string {
+	players := fmt.Sprintf("%s (US) v %s (USSR)", tg.Sides[USA], tg.Sides[SOV])
+	switch {
+	case tg.Err != nil:
+		return fmt.Sprintf("Game %d, %s: %s", tg.N, players, tg.Err.Error())
+	case tg.Winner == NEU:
+		return fmt.Sprintf("Game %d, %s: drawn on %s, turn %d", tg.N, players, tg.Reason, tg.Turn)
+	default:
+		return fmt.Sprintf("Game %d, %s: %s won by %s, turn %d", tg.N, players, tg.Sides[tg.Winner], tg.Reason, tg.Turn)
+	}
+}
+
+// score is a record of wins and draws, a draw counting half a win.
+type score struct {
+	games, wins, draws int
+}
+
+func (sc *score) add(winner, aff Aff) {
+	sc.games++
+	switch winner {
+	case aff:
+		sc.wins++
+	case NEU:
+		sc.draws++
+	}
+}
+
+func (sc score) rate() float64 {
+	return (float64(sc.wins) + float64(sc.draws)/2) / float64(sc.games)
+}
+
+// interval returns the 95% confidence interval of the rate, by Wilson's score
+// interval.
+func (sc score) interval() (lo, hi float64) {
+	const z = 1.96
+	n := float64(sc.games)
+	p := sc.rate()
+	centre := (p + z*z/(2*n)) / (1 + z*z/n)
+	half := z * math.Sqrt(p*(1-p)/n+z*z/(4*n*n)) / (1 + z*z/n)
+	return math.Max(centre-half, 0), math.Min(centre+half, 1)
+}
+
+func (sc score) String() string {
+	if sc.games == 0 {
+		return "0\t-\t-\t-\t-"
+	}
+	lo, hi := sc.interval()
+	return fmt.Sprintf("%d\t%d\t%d\t%.1f%%\t%.1f%%-%.1f%%", sc.games, sc.wins, sc.draws, 100*sc.rate(), 100*lo, 100*hi)
+}
+
+// WriteTournamentReport writes a summary of the games: how often each bot
+// won, as a whole and on each side, how often each side won, and how long the
+// games went. Draws count half a win.
+func WriteTournamentReport(w io.Writer, kinds [2]string, games []TournamentGame) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	p := func(format string, args ...interface{}) {
+		fmt.Fprintf(tw, format, args...)
+	}
+	bots := map[string]*score{}
+	bySide := map[string]*[2]score{}
+	for _, kind := range kinds {
+		bots[kind] = &score{}
+		bySide[kind] = &[2]score{}
+	}
+	sides := [2]score{}
+	reasons := map[string]int{}
+	failed, totalVP, totalTurns := 0, 0, 0
+	for _, tg := range games {
+		if tg.Err != nil {
+			failed++
+			continue
+		}
+		for _, aff := range []Aff{USA, SOV} {
+			kind := tg.Sides[aff]
+			bySide[kind][aff].add(tg.Winner, aff)
+			sides[aff].add(tg.Winner, aff)
+			// Playing itself, a bot wins every game bar draws.
+			if kinds[0] != kinds[1] {
+				bots[kind].add(tg.Winner, aff)
+			}
+		}
+		reasons[tg.Reason]++
+		totalVP += tg.VP
+		totalTurns += tg.Turn
+	}
+	played := len(games) - failed
+	p("Games\t%d\n", len(games))
+	if failed > 0 {
+		p("Failed\t%d\n", failed)
+	}
+	if played == 0 {
+		return tw.Flush()
+	}
+	p("\nBot\tGames\tWins\tDraws\tWin rate\t95%% interval\n")
+	uniq := []string{kinds[0]}
+	if kinds[1] != kinds[0] {
+		uniq = append(uniq, kinds[1])
+	}
+	for _, kind := range uniq {
+		if kinds[0] != kinds[1] {
+			p("%s\t%s\n", kind, bots[kind])
+		}
+		for _, aff := range []Aff{USA, SOV} {
+			p("%s as %s\t%s\n", kind, aff, bySide[kind][aff])
+		}
+	}
+	p("\nSide\tGames\tWins\tDraws\tWin rate\t95%% interval\n")
+	for _, aff := range []Aff{USA, SOV} {
+		p("%s\t%s\n", aff, sides[aff])
+	}
+	p("\nAverage final VP\t%+.1f\n", float64(totalVP)/float64(played))
+	p("Average length\t%.1f turns\n", float64(totalTurns)/float64(played))
+	p("\nEnded by\tGames\n")
+	for _, reason := range sortedKeys(reasons) {
+		p("%s\t%d\n", reason, reasons[reason])
+	}
+	return tw.Flush()
+}
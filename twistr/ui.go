@@ -2,6 +2,8 @@ package twistr
 
 import "bytes"
 import "fmt"
+import "io"
+import "strconv"
 import "strings"
 
 type Mode interface {
@@ -70,6 +72,25 @@ func (m *SpaceMode) Command(raw string) bool {
 	return false
 }
 
+// ScoringMode shows what each region would score now, following the board
+// as it changes.
+type ScoringMode struct {
+	game *Game
+}
+
+func NewScoringMode(g *Game) *ScoringMode {
+	return &ScoringMode{g}
+}
+
+func (m *ScoringMode) Display(ui UI) Mode {
+	ui.ShowMessages(ScoringSummary(m.game))
+	return m
+}
+
+func (m *ScoringMode) Command(raw string) bool {
+	return false
+}
+
 type CardMode struct {
 	cards []Card
 	start int
@@ -110,7 +131,7 @@ func modal(s *State, command string) bool {
 	cmd, args := parseCommand(command)
 	switch cmd {
 	case "help":
-		s.UI.Message("Commands: 'undo' 'hand' 'log' 'spacerace' 'board' 'card <card>'")
+		s.UI.Message("Commands: 'undo' 'hand' 'log' 'spacerace' 'scoring' 'board' 'card <card>' 'legal' 'hint' 'odds [country]'")
 	case "hand":
 		ShowHand(s, s.LocalPlayer, s.LocalPlayer, true)
 	case "log":
@@ -119,6 +140,9 @@ func modal(s *State, command string) bool {
 	case "spacerace":
 		s.Enter(NewSpaceMode(s.Game.SpaceRace))
 		s.Redraw(s.Game)
+	case "scoring":
+		s.Enter(NewScoringMode(s.Game))
+		s.Redraw(s.Game)
 	case "board":
 		s.Enter(nil)
 		s.Redraw(s.Game)
@@ -148,6 +172,25 @@ func modal(s *State, command string) bool {
 		}
 		s.Enter(NewCardMode([]Card{card}))
 		s.Redraw(s.Game)
+	case "legal":
+		if s.Asking == nil {
+			break
+		}
+		if legal := s.Asking.Legal(s); legal != nil {
+			s.UI.Message("Legal: " + strings.Join(legal, " "))
+		} else {
+			s.UI.Message("The legal answers cannot be listed here.")
+		}
+	case "hint":
+		n := 3
+		if len(args) == 1 {
+			if m, err := strconv.Atoi(args[0]); err == nil && m > 0 {
+				n = m
+			}
+		}
+		ShowHints(s, n)
+	case "odds":
+		ShowOdds(s, args)
 	case "barf":
 		s.History.Dump()
 	case "undo":
@@ -168,25 +211,11 @@ func modal(s *State, command string) bool {
 	return true
 }
 
-func localInput(s *State, inp interface{}, message string, choices ...string) {
+func Input(ui UI, inp interface{}, message string, choices ...string) {
 	var err error
-	validChoice := func(in string) bool {
-		if len(choices) == 0 {
-			return true
-		}
-		for _, choice := range choices {
-			if choice == in {
-				return true
-			}
-		}
-		return false
-	}
 retry:
-	inputStr := Solicit(s.UI, message, choices)
-	if ok := modal(s, inputStr); ok {
-		goto retry
-	}
-	if len(choices) > 0 && !validChoice(inputStr) {
+	inputStr := Solicit(ui, message, choices)
+	if len(choices) > 0 && !validChoice(inputStr, choices) {
 		err = fmt.Errorf("'%s' is not a valid choice", inputStr)
 	} else {
 		err = Unmarshal(inputStr, inp)
@@ -197,39 +226,31 @@ retry:
 	}
 }
 
-func Input(ui UI, inp interface{}, message string, choices ...string) {
-	var err error
-	validChoice := func(in string) bool {
-		if len(choices) == 0 {
+func validChoice(in string, choices []string) bool {
+	if len(choices) == 0 {
+		return true
+	}
+	for _, choice := range choices {
+		if choice == in {
 			return true
 		}
-		for _, choice := range choices {
-			if choice == in {
-				return true
-			}
-		}
-		return false
-	}
-retry:
-	inputStr := Solicit(ui, message, choices)
-	if len(choices) > 0 && !validChoice(inputStr) {
-		err = fmt.Errorf("'%s' is not a valid choice", inputStr)
-	} else {
-		err = Unmarshal(inputStr, inp)
-	}
-	if err != nil {
-		message = err.Error() + ". Try again?"
-		goto retry
 	}
+	return false
 }
 
+// Solicit shows message and returns the reply. If the UI has no more input,
+// as when stdin is closed, no one is left to answer: it panics with io.EOF,
+// which Match.Start returns as its error.
 func Solicit(ui UI, message string, choices []string) (reply string) {
 	buf := bytes.NewBufferString(strings.TrimRight(message, "\n"))
 	if len(choices) > 0 {
 		fmt.Fprintf(buf, " [ %s ]", strings.Join(choices, " "))
 	}
 	ui.Message(buf.String())
-	reply, _ = ui.Input()
+	reply, err := ui.Input()
+	if err == io.EOF {
+		panic(io.EOF)
+	}
 	return
 }
 
@@ -244,3 +265,18 @@ type UI interface {
 	Redraw(*Game)
 	Close() error
 }
+
+// NullUI shows nothing, for games run without a player watching. Nothing
+// should ever ask it for input.
+type NullUI struct{}
+
+func (NullUI) Input() (string, error) {
+	panic("NullUI asked for input")
+}
+
+func (NullUI) Message(message string) error { return nil }
+func (NullUI) ShowMessages([]string)        {}
+func (NullUI) ShowCards([]Card)             {}
+func (NullUI) ShowSpaceRace([2]int)         {}
+func (NullUI) Redraw(*Game)                 {}
+func (NullUI) Close() error                 { return nil }
@@ -5,11 +5,6 @@ import "fmt"
 import "go/doc"
 import "math/rand"
 import "strings"
-import "time"
-
-var (
-	rng *rand.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
-)
 
 type Mod struct {
 	Diff int
@@ -62,6 +57,6 @@ func wordWrap(body string, columns int) []string {
 	return strings.Split(b.String(), "\n")
 }
 
-func Roll() int {
-	return rng.Intn(6) + 1
+func Roll(r *rand.Rand) int {
+	return r.Intn(6) + 1
 }